@@ -1,52 +1,13 @@
 package main
 
 import (
-	"context"
 	"strings"
 	"testing"
-	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
-	"github.com/catatsuy/utsuro/internal/server"
+	"github.com/catatsuy/utsuro/server/utsurotest"
 )
 
-func startUtsuroServer(t *testing.T) (string, func()) {
-	t.Helper()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	srv := server.NewServer(server.Config{
-		ListenAddr: "127.0.0.1:0",
-	})
-
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- srv.Serve(ctx)
-	}()
-
-	select {
-	case <-srv.Ready():
-	case err := <-errCh:
-		t.Fatalf("server failed before ready: %v", err)
-	case <-time.After(3 * time.Second):
-		t.Fatalf("server did not become ready")
-	}
-
-	addr := srv.Addr()
-	if addr == "" {
-		t.Fatalf("server address is empty")
-	}
-
-	stop := func() {
-		cancel()
-		select {
-		case <-errCh:
-		case <-time.After(3 * time.Second):
-			t.Fatalf("server shutdown timeout")
-		}
-	}
-	return addr, stop
-}
-
 func mustSet(t *testing.T, c *memcache.Client, it *memcache.Item) {
 	t.Helper()
 	if err := c.Set(it); err != nil {
@@ -55,8 +16,7 @@ func mustSet(t *testing.T, c *memcache.Client, it *memcache.Item) {
 }
 
 func TestGomemcacheWithUtsuro(t *testing.T) {
-	addr, stop := startUtsuroServer(t)
-	defer stop()
+	addr := utsurotest.NewServer(t).Addr()
 
 	c := memcache.New(addr)
 
@@ -0,0 +1,502 @@
+package cache
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"unsafe"
+)
+
+const (
+	// arenaPageSize is the unit the arena allocates and compacts.
+	arenaPageSize = 1 << 20
+	// arenaMinChunk is the smallest slab class; classes grow by 1.25x up to
+	// a whole page. Larger entries get a page of their own.
+	arenaMinChunk = 64
+	// arenaPagePool is how many emptied pages are kept for reuse by any
+	// class before their memory is released.
+	arenaPagePool = 4
+	// chunkHeader holds the owning entry index so compaction can move chunks.
+	chunkHeader = 4
+)
+
+// arenaEntryOverhead approximates the heap held per entry outside its chunk:
+// its arenaEntry and index map slot.
+var arenaEntryOverhead = int64(unsafe.Sizeof(arenaEntry{})) + 24
+
+// arenaClasses are the chunk sizes of the slab classes.
+var arenaClasses = func() []int32 {
+	var sizes []int32
+	for size := int32(arenaMinChunk); size < arenaPageSize; size = (size*5/4 + 7) &^ 7 {
+		sizes = append(sizes, size)
+	}
+	return append(sizes, arenaPageSize)
+}()
+
+// arenaEntry describes one item. It holds no pointers so neither it nor the
+// index needs scanning by the garbage collector.
+type arenaEntry struct {
+	hash uint64
+	// chain links entries with the same hash; prev and next link the LRU
+	// list from the most recently used head. -1 ends each list.
+	chain int32
+	prev  int32
+	next  int32
+
+	// class is -1 for an entry with a page of its own, and page is -1 while
+	// the entry is unused.
+	class    int32
+	page     int32
+	offset   int32
+	keyLen   int32
+	valueLen int32
+
+	flags      uint32
+	compressed bool
+	chunked    bool
+	size       int64
+	cas        uint64
+	expUnix    int64
+}
+
+type chunkRef struct {
+	page   int32
+	offset int32
+}
+
+type slabClass struct {
+	size  int32
+	pages []int32
+	free  []chunkRef
+}
+
+// arenaStore keeps keys and values in large pages split into fixed size
+// chunks, one slab class per chunk size, and refers to them by page and
+// offset. When a class accumulates two pages worth of free chunks, its least
+// used page is compacted into the others and handed back to a pool shared by
+// all classes, so memory follows the mix of item sizes.
+type arenaStore struct {
+	seed    maphash.Seed
+	index   map[uint64]int32
+	entries []arenaEntry
+	unused  []int32
+	head    int32
+	tail    int32
+	count   int
+
+	// large holds the value chunks of entries with values longer than
+	// ChunkSize, which stay outside the pages.
+	large map[int32][][]byte
+
+	pages     [][]byte
+	pageLive  []int32
+	pageClass []int32
+	pagePool  []int32
+	pageIDs   []int32
+	classes   []slabClass
+}
+
+func newArenaStore() *arenaStore {
+	s := &arenaStore{seed: maphash.MakeSeed()}
+	s.reset()
+	return s
+}
+
+func (s *arenaStore) reset() {
+	s.index = make(map[uint64]int32)
+	s.large = make(map[int32][][]byte)
+	s.entries = nil
+	s.unused = nil
+	s.head, s.tail = -1, -1
+	s.count = 0
+	s.pages = nil
+	s.pageLive = nil
+	s.pageClass = nil
+	s.pagePool = nil
+	s.pageIDs = nil
+	s.classes = make([]slabClass, len(arenaClasses))
+	for i, size := range arenaClasses {
+		s.classes[i].size = size
+	}
+}
+
+// stableValues is false: values are overwritten in place, moved by
+// compaction and their pages reused.
+func (s *arenaStore) stableValues() bool {
+	return false
+}
+
+func (s *arenaStore) len() int {
+	return s.count
+}
+
+func (s *arenaStore) entrySize(keyLen, valueLen int) int64 {
+	if valueLen > ChunkSize {
+		return s.entrySize(keyLen, 0) + valueHeapSize(valueLen) + mapSlotOverhead
+	}
+	n := int64(chunkHeader + keyLen + valueLen)
+	if class := classFor(n); class >= 0 {
+		return int64(arenaClasses[class]) + arenaEntryOverhead
+	}
+	return allocSize(n) + arenaEntryOverhead
+}
+
+// classFor returns the smallest class holding n bytes, or -1.
+func classFor(n int64) int32 {
+	lo, hi := 0, len(arenaClasses)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if int64(arenaClasses[mid]) < n {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == len(arenaClasses) {
+		return -1
+	}
+	return int32(lo)
+}
+
+func (s *arenaStore) get(key string, touch bool) (Item, bool) {
+	idx, _ := s.find(key)
+	if idx < 0 {
+		return Item{}, false
+	}
+	if touch {
+		s.unlinkLRU(idx)
+		s.pushFront(idx)
+	}
+	return s.item(idx), true
+}
+
+func (s *arenaStore) item(idx int32) Item {
+	e := &s.entries[idx]
+	item := Item{
+		Flags:      e.flags,
+		Size:       e.size,
+		CAS:        e.cas,
+		ExpUnix:    e.expUnix,
+		Compressed: e.compressed,
+	}
+	if e.chunked {
+		item.Chunks = s.large[idx]
+	} else {
+		item.Value = s.value(e)
+	}
+	return item
+}
+
+func (s *arenaStore) put(key string, item Item) {
+	n := int64(chunkHeader + len(key) + len(item.Value))
+	idx, _ := s.find(key)
+	if idx < 0 {
+		h := maphash.String(s.seed, key)
+		idx = s.newEntry()
+		chain, ok := s.index[h]
+		if !ok {
+			chain = -1
+		}
+		s.entries[idx].hash = h
+		s.entries[idx].chain = chain
+		s.index[h] = idx
+		s.placeChunk(idx, n)
+		s.count++
+	} else {
+		s.unlinkLRU(idx)
+		if e := &s.entries[idx]; e.class < 0 || e.class != classFor(n) {
+			// item.Value may alias the old chunk, so free it only after the
+			// new one is written.
+			old := *e
+			s.placeChunk(idx, n)
+			s.writeChunk(idx, key, item.Value)
+			s.freeChunk(&old)
+			s.finishPut(idx, item)
+			return
+		}
+	}
+	s.writeChunk(idx, key, item.Value)
+	s.finishPut(idx, item)
+}
+
+func (s *arenaStore) finishPut(idx int32, item Item) {
+	e := &s.entries[idx]
+	e.flags = item.Flags
+	e.size = item.Size
+	e.cas = item.CAS
+	e.expUnix = item.ExpUnix
+	e.compressed = item.Compressed
+	e.chunked = item.Chunks != nil
+	if e.chunked {
+		s.large[idx] = item.Chunks
+	} else {
+		delete(s.large, idx)
+	}
+	s.pushFront(idx)
+}
+
+func (s *arenaStore) remove(key string) (Item, bool) {
+	idx, prevChain := s.find(key)
+	if idx < 0 {
+		return Item{}, false
+	}
+	item := s.item(idx)
+	e := &s.entries[idx]
+	delete(s.large, idx)
+
+	if prevChain >= 0 {
+		s.entries[prevChain].chain = e.chain
+	} else if e.chain >= 0 {
+		s.index[e.hash] = e.chain
+	} else {
+		delete(s.index, e.hash)
+	}
+	s.unlinkLRU(idx)
+	old := *e
+	e.page = -1
+	s.unused = append(s.unused, idx)
+	s.count--
+	s.freeChunk(&old)
+	return item, true
+}
+
+func (s *arenaStore) victim(protectKey string, now int64) (string, bool, bool) {
+	fallback := int32(-1)
+	for idx := s.tail; idx >= 0; idx = s.entries[idx].prev {
+		e := &s.entries[idx]
+		if string(s.key(e)) == protectKey {
+			continue
+		}
+		if e.expUnix > 0 && e.expUnix <= now {
+			return string(s.key(e)), true, true
+		}
+		if fallback < 0 {
+			fallback = idx
+		}
+	}
+	if fallback < 0 {
+		return "", false, false
+	}
+	return string(s.key(&s.entries[fallback])), false, true
+}
+
+// find returns key's entry and the entry before it in its hash chain, or -1.
+func (s *arenaStore) find(key string) (idx, prevChain int32) {
+	h := maphash.String(s.seed, key)
+	idx, ok := s.index[h]
+	if !ok {
+		return -1, -1
+	}
+	prevChain = -1
+	for ; idx >= 0; idx = s.entries[idx].chain {
+		e := &s.entries[idx]
+		if e.hash == h && string(s.key(e)) == key {
+			return idx, prevChain
+		}
+		prevChain = idx
+	}
+	return -1, -1
+}
+
+func (s *arenaStore) key(e *arenaEntry) []byte {
+	start := e.offset + chunkHeader
+	return s.pages[e.page][start : start+e.keyLen]
+}
+
+func (s *arenaStore) value(e *arenaEntry) []byte {
+	start := e.offset + chunkHeader + e.keyLen
+	end := start + e.valueLen
+	return s.pages[e.page][start:end:end]
+}
+
+func (s *arenaStore) newEntry() int32 {
+	if n := len(s.unused); n > 0 {
+		idx := s.unused[n-1]
+		s.unused = s.unused[:n-1]
+		return idx
+	}
+	s.entries = append(s.entries, arenaEntry{})
+	return int32(len(s.entries) - 1)
+}
+
+func (s *arenaStore) pushFront(idx int32) {
+	e := &s.entries[idx]
+	e.prev = -1
+	e.next = s.head
+	if s.head >= 0 {
+		s.entries[s.head].prev = idx
+	}
+	s.head = idx
+	if s.tail < 0 {
+		s.tail = idx
+	}
+}
+
+func (s *arenaStore) unlinkLRU(idx int32) {
+	e := &s.entries[idx]
+	if e.prev >= 0 {
+		s.entries[e.prev].next = e.next
+	} else {
+		s.head = e.next
+	}
+	if e.next >= 0 {
+		s.entries[e.next].prev = e.prev
+	} else {
+		s.tail = e.prev
+	}
+	e.prev, e.next = -1, -1
+}
+
+// placeChunk gives entry idx a chunk of at least n bytes.
+func (s *arenaStore) placeChunk(idx int32, n int64) {
+	class := classFor(n)
+	var ref chunkRef
+	if class < 0 {
+		ref = chunkRef{page: s.newPage(int(n), -1)}
+	} else {
+		ref = s.allocChunk(class)
+	}
+	s.pageLive[ref.page]++
+	e := &s.entries[idx]
+	e.class = class
+	e.page = ref.page
+	e.offset = ref.offset
+}
+
+func (s *arenaStore) writeChunk(idx int32, key string, value []byte) {
+	e := &s.entries[idx]
+	chunk := s.pages[e.page][e.offset:]
+	binary.LittleEndian.PutUint32(chunk, uint32(idx))
+	copy(chunk[chunkHeader:], key)
+	copy(chunk[chunkHeader+len(key):], value)
+	e.keyLen = int32(len(key))
+	e.valueLen = int32(len(value))
+}
+
+func (s *arenaStore) allocChunk(class int32) chunkRef {
+	c := &s.classes[class]
+	if len(c.free) == 0 {
+		page := s.newPage(arenaPageSize, class)
+		c.pages = append(c.pages, page)
+		for off := arenaPageSize/c.size*c.size - c.size; off >= 0; off -= c.size {
+			c.free = append(c.free, chunkRef{page: page, offset: off})
+		}
+	}
+	ref := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	return ref
+}
+
+func (s *arenaStore) freeChunk(e *arenaEntry) {
+	s.pageLive[e.page]--
+	if e.class < 0 {
+		s.releasePage(e.page)
+		return
+	}
+	c := &s.classes[e.class]
+	c.free = append(c.free, chunkRef{page: e.page, offset: e.offset})
+	if s.pageLive[e.page] == 0 {
+		s.dropClassPage(e.class, e.page)
+		return
+	}
+	if perPage := int(arenaPageSize / c.size); len(c.free) >= 2*perPage {
+		s.compact(e.class)
+	}
+}
+
+// compact moves the live chunks of class's least used page into free chunks
+// on its other pages and releases that page.
+func (s *arenaStore) compact(class int32) {
+	c := &s.classes[class]
+	victim := int32(-1)
+	for _, page := range c.pages {
+		if victim < 0 || s.pageLive[page] < s.pageLive[victim] {
+			victim = page
+		}
+	}
+	if victim < 0 {
+		return
+	}
+	perPage := arenaPageSize / c.size
+	if len(c.free)-int(perPage-s.pageLive[victim]) < int(s.pageLive[victim]) {
+		return
+	}
+	free := c.free[:0]
+	for _, ref := range c.free {
+		if ref.page != victim {
+			free = append(free, ref)
+		}
+	}
+	c.free = free
+
+	src := s.pages[victim]
+	for off := int32(0); off+c.size <= arenaPageSize && s.pageLive[victim] > 0; off += c.size {
+		idx := int32(binary.LittleEndian.Uint32(src[off:]))
+		if idx < 0 || int(idx) >= len(s.entries) {
+			continue
+		}
+		e := &s.entries[idx]
+		if e.page != victim || e.offset != off {
+			continue
+		}
+		ref := c.free[len(c.free)-1]
+		c.free = c.free[:len(c.free)-1]
+		copy(s.pages[ref.page][ref.offset:ref.offset+c.size], src[off:off+c.size])
+		e.page, e.offset = ref.page, ref.offset
+		s.pageLive[ref.page]++
+		s.pageLive[victim]--
+	}
+	s.dropClassPage(class, victim)
+}
+
+// dropClassPage removes an empty page from class and releases it.
+func (s *arenaStore) dropClassPage(class, page int32) {
+	c := &s.classes[class]
+	for i, p := range c.pages {
+		if p == page {
+			c.pages = append(c.pages[:i], c.pages[i+1:]...)
+			break
+		}
+	}
+	free := c.free[:0]
+	for _, ref := range c.free {
+		if ref.page != page {
+			free = append(free, ref)
+		}
+	}
+	c.free = free
+	s.releasePage(page)
+}
+
+func (s *arenaStore) newPage(size int, class int32) int32 {
+	if size == arenaPageSize {
+		if n := len(s.pagePool); n > 0 {
+			page := s.pagePool[n-1]
+			s.pagePool = s.pagePool[:n-1]
+			s.pageClass[page] = class
+			return page
+		}
+	}
+	mem := make([]byte, size)
+	if n := len(s.pageIDs); n > 0 {
+		page := s.pageIDs[n-1]
+		s.pageIDs = s.pageIDs[:n-1]
+		s.pages[page] = mem
+		s.pageClass[page] = class
+		return page
+	}
+	s.pages = append(s.pages, mem)
+	s.pageLive = append(s.pageLive, 0)
+	s.pageClass = append(s.pageClass, class)
+	return int32(len(s.pages) - 1)
+}
+
+func (s *arenaStore) releasePage(page int32) {
+	if s.pageClass[page] >= 0 && len(s.pagePool) < arenaPagePool {
+		s.pageClass[page] = -1
+		s.pagePool = append(s.pagePool, page)
+		return
+	}
+	s.pages[page] = nil
+	s.pageClass[page] = -1
+	s.pageIDs = append(s.pageIDs, page)
+}
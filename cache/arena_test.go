@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+func TestArenaStoreMatchesHeapStore(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	want := newHeapStore()
+	got := newArenaStore()
+	sizes := []int{0, 1, 50, 100, 700, 5000, 90000, arenaPageSize + 1}
+
+	for i := 0; i < 20000; i++ {
+		key := "k" + strconv.Itoa(rng.IntN(2000))
+		switch op := rng.IntN(10); {
+		case op < 5:
+			value := bytes.Repeat([]byte{byte(i)}, sizes[rng.IntN(len(sizes))]/(1+rng.IntN(4)))
+			item := Item{Value: value, Flags: uint32(i), Size: int64(len(value)), CAS: uint64(i)}
+			want.put(key, item)
+			got.put(key, item)
+		case op < 8:
+			w, wok := want.get(key, op == 5)
+			g, gok := got.get(key, op == 5)
+			if wok != gok || !bytes.Equal(w.Value, g.Value) || w.Flags != g.Flags || w.CAS != g.CAS {
+				t.Fatalf("op %d: get %s = %v %v, want %v %v", i, key, g.Flags, gok, w.Flags, wok)
+			}
+		default:
+			w, wok := want.remove(key)
+			g, gok := got.remove(key)
+			if wok != gok || len(w.Value) != len(g.Value) {
+				t.Fatalf("op %d: remove %s = %v, want %v", i, key, gok, wok)
+			}
+		}
+		if want.len() != got.len() {
+			t.Fatalf("op %d: len = %d, want %d", i, got.len(), want.len())
+		}
+	}
+
+	// Both keep the same LRU order.
+	for want.len() > 0 {
+		wk, _, _ := want.victim("", 0)
+		gk, _, _ := got.victim("", 0)
+		if wk != gk {
+			t.Fatalf("victim = %q, want %q", gk, wk)
+		}
+		want.remove(wk)
+		got.remove(gk)
+	}
+}
+
+func TestArenaCompactionReleasesPages(t *testing.T) {
+	s := newArenaStore()
+	value := bytes.Repeat([]byte("v"), 100)
+	const n = 200000
+	for i := 0; i < n; i++ {
+		s.put("key:"+strconv.Itoa(i), Item{Value: value})
+	}
+	full := livePages(s)
+
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			s.remove("key:" + strconv.Itoa(i))
+		}
+	}
+	if got := livePages(s); got > full/4 {
+		t.Fatalf("live pages after removing 90%% of items = %d, was %d", got, full)
+	}
+	for i := 0; i < n; i += 10 {
+		item, ok := s.get("key:"+strconv.Itoa(i), false)
+		if !ok || !bytes.Equal(item.Value, value) {
+			t.Fatalf("key:%d lost or corrupted by compaction", i)
+		}
+	}
+}
+
+func livePages(s *arenaStore) int {
+	n := 0
+	for _, page := range s.pages {
+		if page != nil {
+			n++
+		}
+	}
+	return n - len(s.pagePool)
+}
+
+func TestArenaCache(t *testing.T) {
+	c := New(WithMaxBytes(1<<20), WithArena())
+	if err := c.Set("k", 7, []byte("10")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if v, err := c.Incr("k", 5); err != nil || v != 15 {
+		t.Fatalf("incr = %d, %v", v, err)
+	}
+	item, ok := c.Get("k")
+	if !ok || string(item.Value) != "15" || item.Flags != 7 {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	if err := c.Set("j", 0, []byte("other")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	var buf []byte
+	hits := c.ViewMulti([]string{"k", "missing", "j"}, nil, &buf)
+	if len(hits) != 2 || string(hits[0].Item.Value) != "15" || string(hits[1].Item.Value) != "other" {
+		t.Fatalf("unexpected hits: %+v", hits)
+	}
+
+	// Fill well past the limit; the oldest items are evicted.
+	value := make([]byte, 1000)
+	for i := 0; i < 5000; i++ {
+		if err := c.Set("key:"+strconv.Itoa(i), 0, value); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	st := c.Stats()
+	if st.UsedBytes > st.MaxBytes || st.EvictedCapacity == 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if _, ok := c.Get("key:0"); ok {
+		t.Fatal("oldest key should be evicted")
+	}
+	if _, ok := c.Get("key:4999"); !ok {
+		t.Fatal("newest key should remain")
+	}
+}
@@ -0,0 +1,710 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrObjectTooLarge = errors.New("object too large")
+	ErrNoSpace        = errors.New("out of memory")
+	ErrNonNumeric     = errors.New("cannot increment or decrement non-numeric value")
+	ErrOverflow       = errors.New("increment or decrement overflow")
+	ErrInvalidLimit   = errors.New("invalid memory limit")
+)
+
+type Cache struct {
+	mu sync.Mutex
+
+	// maxBytes is the enforced limit. While Resize shrinks the cache it stays
+	// above wantMaxBytes, the configured limit, until enough items are evicted.
+	maxBytes     int64
+	wantMaxBytes int64
+	targetBytes  int64
+	usedBytes    int64
+
+	// logicalBytes and heapBytes track key plus value lengths and the
+	// estimated heap footprint, whichever of them usedBytes is charged with.
+	logicalBytes int64
+	heapBytes    int64
+
+	store store
+
+	entryOverhead int64
+	maxEvictPerOp int
+
+	incrSlidingTTLSeconds int64
+	nextCAS               uint64
+
+	evictedCapacity int64
+	evictedExpired  int64
+
+	// loadMu guards the GetOrLoad state: loads in flight and recent loader
+	// failures.
+	loadMu             sync.Mutex
+	loads              map[string]*loadCall
+	loadFailures       map[string]loadFailure
+	negativeTTLSeconds int64
+}
+
+// Stats is a snapshot of cache usage. UsedBytes is what MaxBytes limits;
+// LogicalBytes counts key and value lengths only and HeapBytes estimates the
+// Go heap held by the items. Evictions are split by whether the removed item
+// was still live (capacity) or had already expired; expired items found on
+// access count as expired evictions too.
+type Stats struct {
+	Items           int64
+	UsedBytes       int64
+	LogicalBytes    int64
+	HeapBytes       int64
+	MaxBytes        int64
+	TargetBytes     int64
+	EvictedCapacity int64
+	EvictedExpired  int64
+	MaxEvictPerOp   int
+	IncrSlidingTTL  time.Duration
+}
+
+type Item struct {
+	Value []byte
+
+	Flags uint32
+	Size  int64
+	CAS   uint64
+
+	// ExpUnix is Unix seconds. 0 means no expiration.
+	ExpUnix int64
+
+	// Compressed marks a Value the caller compressed with flate, as the
+	// server does; the cache stores it as is and treats it as non-numeric.
+	// AppendValue decompresses it.
+	Compressed bool
+
+	// Chunks holds values longer than ChunkSize instead of Value. Chunks are
+	// never modified once stored.
+	Chunks [][]byte
+}
+
+var nowUnix = func() int64 { return time.Now().Unix() }
+
+// New returns an empty Cache configured by opts.
+func New(opts ...Option) *Cache {
+	c := &Cache{
+		maxBytes:      DefaultMaxBytes,
+		entryOverhead: autoEntryOverhead,
+		maxEvictPerOp: 64,
+		nextCAS:       1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.store == nil {
+		c.store = newHeapStore()
+	} else {
+		c.entryOverhead = autoEntryOverhead
+	}
+	if c.targetBytes <= 0 || c.targetBytes > c.maxBytes {
+		c.targetBytes = c.maxBytes * 95 / 100
+	}
+	c.wantMaxBytes = c.maxBytes
+	return c
+}
+
+// Stats returns a snapshot of usage and evictions.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Items:           int64(c.store.len()),
+		UsedBytes:       c.usedBytes,
+		LogicalBytes:    c.logicalBytes,
+		HeapBytes:       c.heapBytes,
+		MaxBytes:        c.wantMaxBytes,
+		TargetBytes:     c.targetBytes,
+		EvictedCapacity: c.evictedCapacity,
+		EvictedExpired:  c.evictedExpired,
+		MaxEvictPerOp:   c.maxEvictPerOp,
+		IncrSlidingTTL:  time.Duration(c.incrSlidingTTLSeconds) * time.Second,
+	}
+}
+
+func (c *Cache) Get(key string) (*Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.getLocked(key, nowUnix())
+	if !ok {
+		return nil, false
+	}
+	return cloneItem(&item), true
+}
+
+// View is Get without the copy. The returned Value must not be modified. It
+// is the stored buffer itself when the storage never writes into stored
+// values, which holds for the default storage; otherwise, as with WithArena,
+// it is copied into *buf, which is grown as needed and kept for the next call.
+func (c *Cache) View(key string, buf *[]byte) (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.getLocked(key, nowUnix())
+	if !ok {
+		return Item{}, false
+	}
+	if item.Chunks == nil && !c.store.stableValues() {
+		*buf = append((*buf)[:0], item.Value...)
+		item.Value = *buf
+	}
+	return item, true
+}
+
+// Hit is an item found by ViewMulti; Index is the position of its key.
+type Hit struct {
+	Index int
+	Item  Item
+}
+
+// Entry is an item to store with SetMulti.
+type Entry struct {
+	Key        string
+	Flags      uint32
+	Value      []byte
+	Compressed bool
+}
+
+// GetMulti is Get for several keys under one lock acquisition. Missing keys
+// get a nil item.
+func (c *Cache) GetMulti(keys []string) []*Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	items := make([]*Item, len(keys))
+	for i, key := range keys {
+		if item, ok := c.getLocked(key, now); ok {
+			items[i] = cloneItem(&item)
+		}
+	}
+	return items
+}
+
+// ViewMulti is View for several keys under one lock acquisition. It appends
+// the items found to hits in key order; values that must be copied are
+// appended to *buf.
+func (c *Cache) ViewMulti(keys []string, hits []Hit, buf *[]byte) []Hit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	*buf = (*buf)[:0]
+	for i, key := range keys {
+		item, ok := c.getLocked(key, now)
+		if !ok {
+			continue
+		}
+		if item.Chunks == nil && !c.store.stableValues() {
+			start := len(*buf)
+			*buf = append(*buf, item.Value...)
+			item.Value = (*buf)[start:len(*buf):len(*buf)]
+		}
+		hits = append(hits, Hit{Index: i, Item: item})
+	}
+	return hits
+}
+
+// SetMulti is Set for several entries under one lock acquisition and returns
+// the error of each.
+func (c *Cache) SetMulti(entries []Entry) []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := make([]error, len(entries))
+	for i, e := range entries {
+		errs[i] = c.setLocked(e.Key, Item{Value: e.Value, Flags: e.Flags, Compressed: e.Compressed})
+	}
+	return errs
+}
+
+// DeleteMulti is Delete for several keys under one lock acquisition and
+// reports which keys were deleted.
+func (c *Cache) DeleteMulti(keys []string) []bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	deleted := make([]bool, len(keys))
+	for i, key := range keys {
+		if _, ok := c.getLocked(key, now); ok {
+			c.removeLocked(key)
+			deleted[i] = true
+		}
+	}
+	return deleted
+}
+
+// getLocked looks key up, touching it, and removes it if expired.
+func (c *Cache) getLocked(key string, now int64) (Item, bool) {
+	item, ok := c.store.get(key, true)
+	if !ok {
+		return Item{}, false
+	}
+	if isExpired(&item, now) {
+		c.removeExpiredLocked(key)
+		return Item{}, false
+	}
+	return item, true
+}
+
+// Peek returns a copy of key's item without updating its LRU position.
+func (c *Cache) Peek(key string) (*Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.store.get(key, false)
+	if !ok || isExpired(&item, nowUnix()) {
+		return nil, false
+	}
+	return cloneItem(&item), true
+}
+
+// Flush removes every item.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store.reset()
+	c.usedBytes = 0
+	c.logicalBytes = 0
+	c.heapBytes = 0
+}
+
+// Resize changes the memory limits. Growing takes effect at once. Shrinking
+// evicts at most maxEvictPerOp items per lock acquisition so other callers
+// interleave, and Resize returns once usage fits. A targetBytes outside
+// (0, maxBytes] defaults to 95% of maxBytes, as in New. If ctx is done first,
+// Resize returns its error; the new limits stay configured and later writes
+// keep evicting toward them.
+func (c *Cache) Resize(ctx context.Context, maxBytes, targetBytes int64) error {
+	if maxBytes <= 0 {
+		return ErrInvalidLimit
+	}
+	if targetBytes <= 0 || targetBytes > maxBytes {
+		targetBytes = maxBytes * 95 / 100
+	}
+
+	c.mu.Lock()
+	c.wantMaxBytes = maxBytes
+	c.targetBytes = targetBytes
+	c.mu.Unlock()
+
+	for !c.shrinkStep() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		runtime.Gosched()
+	}
+	return nil
+}
+
+// shrinkStep evicts one batch toward the configured limits and reports
+// whether the cache now fits them.
+func (c *Cache) shrinkStep() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	evicted := 0
+	for c.usedBytes > c.targetBytes && evicted < c.maxEvictPerOp {
+		if !c.evictOneLocked("", now) {
+			break
+		}
+		evicted++
+	}
+	c.maxBytes = max(c.wantMaxBytes, c.usedBytes)
+	return c.usedBytes <= c.targetBytes || evicted == 0
+}
+
+// SetMaxEvictPerOp changes how many items one operation may evict.
+func (c *Cache) SetMaxEvictPerOp(n int) {
+	if n <= 0 {
+		n = 64
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEvictPerOp = n
+}
+
+// SetIncrSlidingTTL changes the TTL applied by Incr and Decr, rounded up to a
+// second; 0 disables it.
+func (c *Cache) SetIncrSlidingTTL(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.incrSlidingTTLSeconds = ttlSeconds(d)
+}
+
+func (c *Cache) Set(key string, flags uint32, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setLocked(key, Item{Value: value, Flags: flags})
+}
+
+// SetTTL is Set for an item that expires after ttl, rounded up to a second.
+// A ttl <= 0 means no expiration.
+func (c *Cache) SetTTL(key string, flags uint32, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setLocked(key, Item{Value: value, Flags: flags, ExpUnix: c.expiration(ttl)})
+}
+
+// Touch makes key expire after ttl, or never when ttl <= 0, without changing
+// its value or CAS, and reports whether key was present.
+func (c *Cache) Touch(key string, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.store.get(key, false)
+	if !ok {
+		return false
+	}
+	if isExpired(&item, nowUnix()) {
+		c.removeExpiredLocked(key)
+		return false
+	}
+	if item.Chunks == nil && !c.store.stableValues() {
+		// put may reuse the storage the value points into.
+		item.Value = cloneBytes(item.Value)
+	}
+	item.ExpUnix = c.expiration(ttl)
+	c.store.put(key, item)
+	return true
+}
+
+// TTL returns how long key has left to live, or 0 when it never expires, and
+// reports whether key is present.
+func (c *Cache) TTL(key string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	item, ok := c.getLocked(key, now)
+	if !ok {
+		return 0, false
+	}
+	if item.ExpUnix == 0 {
+		return 0, true
+	}
+	return time.Duration(item.ExpUnix-now) * time.Second, true
+}
+
+// SetCompressed is Set for a value the caller compressed. The item is marked
+// Compressed so readers know to decompress it.
+func (c *Cache) SetCompressed(key string, flags uint32, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setLocked(key, Item{Value: value, Flags: flags, Compressed: true})
+}
+
+// SetChunks is Set for a value already split into chunks, which the cache
+// takes ownership of. Every chunk but the last must be ChunkSize long.
+func (c *Cache) SetChunks(key string, flags uint32, chunks [][]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setLocked(key, Item{Chunks: chunks, Flags: flags})
+}
+
+// SetItem is Set for a whole item: its Value or Chunks, Flags, Compressed and
+// ExpUnix are stored, and CAS and Size are assigned by the cache. Chunks are
+// taken over as with SetChunks.
+func (c *Cache) SetItem(key string, item Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setLocked(key, Item{
+		Value:      item.Value,
+		Chunks:     item.Chunks,
+		Flags:      item.Flags,
+		Compressed: item.Compressed,
+		ExpUnix:    item.ExpUnix,
+	})
+}
+
+func (c *Cache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.store.get(key, false)
+	if !ok {
+		return false
+	}
+	if isExpired(&item, nowUnix()) {
+		c.removeExpiredLocked(key)
+		return false
+	}
+	c.removeLocked(key)
+	return true
+}
+
+func (c *Cache) Incr(key string, delta uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	expUnix := c.expirationForIncrDecr(now)
+
+	item, ok := c.store.get(key, false)
+	if !ok {
+		if err := c.setLocked(key, Item{Value: []byte(strconv.FormatUint(delta, 10)), ExpUnix: expUnix}); err != nil {
+			return 0, err
+		}
+		return delta, nil
+	}
+
+	if isExpired(&item, now) {
+		c.removeExpiredLocked(key)
+		if err := c.setLocked(key, Item{Value: []byte(strconv.FormatUint(delta, 10)), ExpUnix: expUnix}); err != nil {
+			return 0, err
+		}
+		return delta, nil
+	}
+
+	cur, err := parseUint(item.Value)
+	if err != nil || item.Compressed {
+		return 0, ErrNonNumeric
+	}
+	if cur > math.MaxUint64-delta {
+		return 0, ErrOverflow
+	}
+	next := cur + delta
+	if err := c.setLocked(key, Item{Value: []byte(strconv.FormatUint(next, 10)), Flags: item.Flags, ExpUnix: expUnix}); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+func (c *Cache) Decr(key string, delta uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := nowUnix()
+	expUnix := c.expirationForIncrDecr(now)
+
+	item, ok := c.store.get(key, false)
+	if !ok {
+		if err := c.setLocked(key, Item{Value: []byte("0"), ExpUnix: expUnix}); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	if isExpired(&item, now) {
+		c.removeExpiredLocked(key)
+		if err := c.setLocked(key, Item{Value: []byte("0"), ExpUnix: expUnix}); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	cur, err := parseUint(item.Value)
+	if err != nil || item.Compressed {
+		return 0, ErrNonNumeric
+	}
+
+	var next uint64
+	if delta >= cur {
+		next = 0
+	} else {
+		next = cur - delta
+	}
+	if err := c.setLocked(key, Item{Value: []byte(strconv.FormatUint(next, 10)), Flags: item.Flags, ExpUnix: expUnix}); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// setLocked stores item's Value, Flags, ExpUnix and Compressed under key.
+func (c *Cache) setLocked(key string, item Item) error {
+	need := c.entrySize(key, item.Len())
+	if need > c.wantMaxBytes {
+		return ErrObjectTooLarge
+	}
+	now := nowUnix()
+	item.Size = need
+	if item.Chunks == nil && len(item.Value) > ChunkSize {
+		item.Chunks = splitChunks(item.Value)
+		item.Value = nil
+	}
+
+	if old, ok := c.store.get(key, false); ok {
+		if isExpired(&old, now) {
+			c.removeExpiredLocked(key)
+		} else {
+			delta := need - old.Size
+			if delta > 0 {
+				c.evictLocked(delta, key, now)
+			}
+			if c.usedBytes+delta > c.maxBytes {
+				return ErrNoSpace
+			}
+
+			c.untrackLocked(len(key), old.Len())
+			item.CAS = c.nextCASLocked()
+			c.store.put(key, item)
+			c.trackLocked(len(key), item.Len())
+			c.usedBytes += delta
+			c.evictBestEffortLocked("", now)
+			return nil
+		}
+	}
+
+	c.evictLocked(need, "", now)
+	if c.usedBytes+need > c.maxBytes {
+		return ErrNoSpace
+	}
+
+	item.CAS = c.nextCASLocked()
+	c.store.put(key, item)
+	c.trackLocked(len(key), item.Len())
+	c.usedBytes += need
+	c.evictBestEffortLocked("", now)
+	return nil
+}
+
+func (c *Cache) evictLocked(incomingDelta int64, protectKey string, now int64) {
+	evicted := 0
+	for c.usedBytes+incomingDelta > c.maxBytes && evicted < c.maxEvictPerOp {
+		if !c.evictOneLocked(protectKey, now) {
+			return
+		}
+		evicted++
+	}
+
+	for c.usedBytes+incomingDelta > c.targetBytes && evicted < c.maxEvictPerOp {
+		if !c.evictOneLocked(protectKey, now) {
+			return
+		}
+		evicted++
+	}
+}
+
+func (c *Cache) evictBestEffortLocked(protectKey string, now int64) {
+	evicted := 0
+	for c.usedBytes > c.targetBytes && evicted < c.maxEvictPerOp {
+		if !c.evictOneLocked(protectKey, now) {
+			return
+		}
+		evicted++
+	}
+}
+
+// evictOneLocked removes one victim and reports whether there was one.
+func (c *Cache) evictOneLocked(protectKey string, now int64) bool {
+	key, expired, ok := c.store.victim(protectKey, now)
+	if !ok {
+		return false
+	}
+	if expired {
+		c.evictedExpired++
+	} else {
+		c.evictedCapacity++
+	}
+	c.removeLocked(key)
+	return true
+}
+
+func (c *Cache) removeExpiredLocked(key string) {
+	c.evictedExpired++
+	c.removeLocked(key)
+}
+
+func (c *Cache) removeLocked(key string) {
+	item, ok := c.store.remove(key)
+	if !ok {
+		return
+	}
+	c.usedBytes -= item.Size
+	if c.usedBytes < 0 {
+		c.usedBytes = 0
+	}
+	c.untrackLocked(len(key), item.Len())
+}
+
+func (c *Cache) trackLocked(keyLen, valueLen int) {
+	c.logicalBytes += int64(keyLen + valueLen)
+	c.heapBytes += c.store.entrySize(keyLen, valueLen)
+}
+
+func (c *Cache) untrackLocked(keyLen, valueLen int) {
+	c.logicalBytes -= int64(keyLen + valueLen)
+	c.heapBytes -= c.store.entrySize(keyLen, valueLen)
+}
+
+func (c *Cache) entrySize(key string, valueLen int) int64 {
+	if c.entryOverhead == autoEntryOverhead {
+		return c.store.entrySize(len(key), valueLen)
+	}
+	return int64(len(key)+valueLen) + c.entryOverhead
+}
+
+func parseUint(value []byte) (uint64, error) {
+	if len(value) == 0 {
+		return 0, fmt.Errorf("empty value")
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// cloneItem copies item. Chunks are never modified, so only their list is
+// copied.
+func cloneItem(item *Item) *Item {
+	out := *item
+	if item.Chunks != nil {
+		out.Chunks = append([][]byte(nil), item.Chunks...)
+	} else {
+		out.Value = cloneBytes(item.Value)
+	}
+	return &out
+}
+
+func cloneBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
+
+func (c *Cache) expiration(ttl time.Duration) int64 {
+	sec := ttlSeconds(ttl)
+	if sec == 0 {
+		return 0
+	}
+	return nowUnix() + sec
+}
+
+func (c *Cache) expirationForIncrDecr(now int64) int64 {
+	if c.incrSlidingTTLSeconds <= 0 {
+		return 0
+	}
+	return now + c.incrSlidingTTLSeconds
+}
+
+func isExpired(item *Item, now int64) bool {
+	return item.ExpUnix > 0 && item.ExpUnix <= now
+}
+
+func (c *Cache) nextCASLocked() uint64 {
+	v := c.nextCAS
+	c.nextCAS++
+	if c.nextCAS == 0 {
+		c.nextCAS = 1
+	}
+	return v
+}
@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIncrMissingCreatesKey(t *testing.T) {
+	c := New(WithMaxBytes(1024), WithTargetBytes(1024), WithEntryOverhead(0))
+	v, err := c.Incr("k", 7)
+	if err != nil {
+		t.Fatalf("incr failed: %v", err)
+	}
+	if v != 7 {
+		t.Fatalf("unexpected value: %d", v)
+	}
+
+	item, ok := c.Get("k")
+	if !ok {
+		t.Fatal("missing key after incr")
+	}
+	if string(item.Value) != "7" {
+		t.Fatalf("unexpected stored value: %q", string(item.Value))
+	}
+}
+
+func TestDecrMissingCreatesZero(t *testing.T) {
+	c := New(WithMaxBytes(1024), WithTargetBytes(1024), WithEntryOverhead(0))
+	v, err := c.Decr("k", 7)
+	if err != nil {
+		t.Fatalf("decr failed: %v", err)
+	}
+	if v != 0 {
+		t.Fatalf("unexpected value: %d", v)
+	}
+
+	item, ok := c.Get("k")
+	if !ok {
+		t.Fatal("missing key after decr")
+	}
+	if string(item.Value) != "0" {
+		t.Fatalf("unexpected stored value: %q", string(item.Value))
+	}
+}
+
+func TestIncrOverflowReturnsError(t *testing.T) {
+	c := New(WithMaxBytes(1024), WithTargetBytes(1024), WithEntryOverhead(0))
+	if err := c.Set("k", 0, []byte("18446744073709551615")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	_, err := c.Incr("k", 1)
+	if err != ErrOverflow {
+		t.Fatalf("expected ErrOverflow, got: %v", err)
+	}
+}
+
+func TestSlidingTTLAndExpiredRecreate(t *testing.T) {
+	c := New(WithMaxBytes(1024), WithTargetBytes(1024), WithEntryOverhead(0), WithIncrSlidingTTL(10*time.Second))
+	now := int64(100)
+	restore := SetNowUnixForTest(func() int64 { return now })
+	defer restore()
+
+	if _, err := c.Incr("k", 5); err != nil {
+		t.Fatalf("incr failed: %v", err)
+	}
+	item, ok := c.Get("k")
+	if !ok {
+		t.Fatal("missing key after incr")
+	}
+	if item.ExpUnix != 110 {
+		t.Fatalf("unexpected exp after incr: %d", item.ExpUnix)
+	}
+
+	now = 120
+	v, err := c.Incr("k", 2)
+	if err != nil {
+		t.Fatalf("incr on expired key failed: %v", err)
+	}
+	if v != 2 {
+		t.Fatalf("expected recreate value 2, got: %d", v)
+	}
+	item, ok = c.Get("k")
+	if !ok {
+		t.Fatal("missing key after recreate")
+	}
+	if item.ExpUnix != 130 {
+		t.Fatalf("unexpected exp after recreate: %d", item.ExpUnix)
+	}
+}
+
+func TestEvictionPrefersExpired(t *testing.T) {
+	c := New(WithMaxBytes(12), WithTargetBytes(12), WithEntryOverhead(0), WithIncrSlidingTTL(10*time.Second))
+	now := int64(100)
+	restore := SetNowUnixForTest(func() int64 { return now })
+	defer restore()
+
+	if err := c.Set("live", 0, []byte("1111")); err != nil {
+		t.Fatalf("set live failed: %v", err)
+	}
+	if _, err := c.Incr("exp", 1); err != nil {
+		t.Fatalf("incr exp failed: %v", err)
+	}
+
+	now = 111 // "exp" is expired, "live" is not expired (no TTL)
+	if err := c.Set("n", 0, []byte("123")); err != nil {
+		t.Fatalf("set n failed: %v", err)
+	}
+
+	if _, ok := c.Get("live"); !ok {
+		t.Fatal("live should remain when expired item exists")
+	}
+	if _, ok := c.Get("exp"); ok {
+		t.Fatal("expired key should be evicted first")
+	}
+	if _, ok := c.Get("n"); !ok {
+		t.Fatal("new key should be stored")
+	}
+}
+
+func TestStatsCountsEvictionReasons(t *testing.T) {
+	c := New(WithMaxBytes(12), WithTargetBytes(12), WithEntryOverhead(0), WithIncrSlidingTTL(10*time.Second))
+	now := int64(100)
+	restore := SetNowUnixForTest(func() int64 { return now })
+	defer restore()
+
+	if _, err := c.Incr("exp", 1); err != nil {
+		t.Fatalf("incr failed: %v", err)
+	}
+	if err := c.Set("a", 0, []byte("1111")); err != nil {
+		t.Fatalf("set a failed: %v", err)
+	}
+
+	now = 111
+	if err := c.Set("b", 0, []byte("2222")); err != nil {
+		t.Fatalf("set b failed: %v", err)
+	}
+	if err := c.Set("c", 0, []byte("3333")); err != nil {
+		t.Fatalf("set c failed: %v", err)
+	}
+
+	st := c.Stats()
+	if st.EvictedExpired != 1 || st.EvictedCapacity != 1 {
+		t.Fatalf("unexpected evictions: %+v", st)
+	}
+	if st.Items != 2 || st.UsedBytes != 10 || st.MaxBytes != 12 {
+		t.Fatalf("unexpected usage: %+v", st)
+	}
+}
+
+func TestResizeShrinksInBatchesAndGrows(t *testing.T) {
+	c := New(WithMaxBytes(100), WithTargetBytes(100), WithEntryOverhead(0), WithMaxEvictPerOp(2))
+	for i := 0; i < 10; i++ {
+		key := string(rune('a' + i))
+		if err := c.Set(key, 0, []byte("123456789")); err != nil {
+			t.Fatalf("set %s failed: %v", key, err)
+		}
+	}
+	if st := c.Stats(); st.UsedBytes != 100 {
+		t.Fatalf("unexpected used bytes: %d", st.UsedBytes)
+	}
+
+	if err := c.Resize(context.Background(), 40, 30); err != nil {
+		t.Fatalf("resize failed: %v", err)
+	}
+	st := c.Stats()
+	if st.UsedBytes != 30 || st.MaxBytes != 40 || st.TargetBytes != 30 || st.EvictedCapacity != 7 {
+		t.Fatalf("unexpected stats after shrink: %+v", st)
+	}
+	// Most recently used items survive.
+	for _, key := range []string{"h", "i", "j"} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("%s should remain after shrink", key)
+		}
+	}
+	if err := c.Set("big", 0, make([]byte, 38)); err != ErrObjectTooLarge {
+		t.Fatalf("expected ErrObjectTooLarge, got: %v", err)
+	}
+
+	if err := c.Resize(context.Background(), 200, 0); err != nil {
+		t.Fatalf("grow failed: %v", err)
+	}
+	if st := c.Stats(); st.MaxBytes != 200 || st.TargetBytes != 190 {
+		t.Fatalf("unexpected stats after grow: %+v", st)
+	}
+	if err := c.Set("big", 0, make([]byte, 38)); err != nil {
+		t.Fatalf("set after grow failed: %v", err)
+	}
+
+	if err := c.Resize(context.Background(), 0, 0); err != ErrInvalidLimit {
+		t.Fatalf("expected ErrInvalidLimit, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Resize(ctx, 10, 0); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if st := c.Stats(); st.UsedBytes <= 10 || st.TargetBytes != 9 {
+		t.Fatalf("unexpected stats after canceled shrink: %+v", st)
+	}
+}
+
+func TestTTLAndTouch(t *testing.T) {
+	now := int64(100)
+	restore := SetNowUnixForTest(func() int64 { return now })
+	defer restore()
+
+	for name, c := range map[string]*Cache{
+		"heap":  New(),
+		"arena": New(WithArena()),
+	} {
+		now = 100
+		if err := c.SetTTL("k", 3, []byte("value"), 1500*time.Millisecond); err != nil {
+			t.Fatalf("%s: set failed: %v", name, err)
+		}
+		if ttl, ok := c.TTL("k"); !ok || ttl != 2*time.Second {
+			t.Fatalf("%s: unexpected ttl: %v %v", name, ttl, ok)
+		}
+		before, _ := c.Get("k")
+
+		if !c.Touch("k", 10*time.Second) {
+			t.Fatalf("%s: touch failed", name)
+		}
+		after, ok := c.Get("k")
+		if !ok || string(after.Value) != "value" || after.Flags != 3 || after.CAS != before.CAS || after.ExpUnix != 110 {
+			t.Fatalf("%s: unexpected item after touch: %+v", name, after)
+		}
+
+		if !c.Touch("k", 0) {
+			t.Fatalf("%s: touch failed", name)
+		}
+		if ttl, ok := c.TTL("k"); !ok || ttl != 0 {
+			t.Fatalf("%s: unexpected ttl without expiry: %v %v", name, ttl, ok)
+		}
+
+		if err := c.SetTTL("short", 0, []byte("v"), time.Second); err != nil {
+			t.Fatalf("%s: set failed: %v", name, err)
+		}
+		now = 101
+		if c.Touch("short", time.Minute) {
+			t.Fatalf("%s: touched expired key", name)
+		}
+		if _, ok := c.TTL("short"); ok {
+			t.Fatalf("%s: expired key has a ttl", name)
+		}
+	}
+}
+
+func TestViewValueSurvivesOverwrite(t *testing.T) {
+	for name, c := range map[string]*Cache{
+		"heap":  New(WithMaxBytes(1 << 20)),
+		"arena": New(WithMaxBytes(1<<20), WithArena()),
+	} {
+		if err := c.Set("k", 1, []byte("first")); err != nil {
+			t.Fatalf("%s: set failed: %v", name, err)
+		}
+		var buf []byte
+		item, ok := c.View("k", &buf)
+		if !ok || string(item.Value) != "first" || item.Flags != 1 {
+			t.Fatalf("%s: unexpected view: %+v", name, item)
+		}
+		if err := c.Set("k", 2, []byte("again")); err != nil {
+			t.Fatalf("%s: set failed: %v", name, err)
+		}
+		if string(item.Value) != "first" {
+			t.Fatalf("%s: viewed value changed to %q", name, item.Value)
+		}
+		if _, ok := c.View("missing", &buf); ok {
+			t.Fatalf("%s: missing key found", name)
+		}
+	}
+}
+
+func TestMultiKeyOperations(t *testing.T) {
+	c := New(WithMaxBytes(1 << 20))
+	errs := c.SetMulti([]Entry{
+		{Key: "a", Flags: 1, Value: []byte("1")},
+		{Key: "b", Flags: 2, Value: []byte("2")},
+		{Key: "big", Value: make([]byte, 2<<20)},
+	})
+	if errs[0] != nil || errs[1] != nil || errs[2] != ErrObjectTooLarge {
+		t.Fatalf("unexpected set errors: %v", errs)
+	}
+
+	items := c.GetMulti([]string{"b", "missing", "a"})
+	if items[0] == nil || string(items[0].Value) != "2" || items[1] != nil || items[2] == nil || items[2].Flags != 1 {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+
+	var buf []byte
+	hits := c.ViewMulti([]string{"missing", "a", "b"}, nil, &buf)
+	if len(hits) != 2 || hits[0].Index != 1 || string(hits[0].Item.Value) != "1" || hits[1].Index != 2 {
+		t.Fatalf("unexpected hits: %+v", hits)
+	}
+
+	deleted := c.DeleteMulti([]string{"a", "missing"})
+	if !deleted[0] || deleted[1] {
+		t.Fatalf("unexpected deleted: %v", deleted)
+	}
+	if st := c.Stats(); st.Items != 1 {
+		t.Fatalf("unexpected items after delete: %d", st.Items)
+	}
+}
+
+func TestLargeValuesAreChunked(t *testing.T) {
+	value := make([]byte, 2*ChunkSize+10)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	for name, c := range map[string]*Cache{
+		"heap":  New(WithMaxBytes(1 << 20)),
+		"arena": New(WithMaxBytes(1<<20), WithArena()),
+	} {
+		if err := c.Set("big", 0, value); err != nil {
+			t.Fatalf("%s: set failed: %v", name, err)
+		}
+		item, ok := c.Get("big")
+		if !ok || item.Value != nil || len(item.Chunks) != 3 || item.Len() != len(value) {
+			t.Fatalf("%s: unexpected item: len=%d chunks=%d", name, item.Len(), len(item.Chunks))
+		}
+		var got []byte
+		for _, chunk := range item.Chunks {
+			got = append(got, chunk...)
+		}
+		if string(got) != string(value) {
+			t.Fatalf("%s: chunks do not match value", name)
+		}
+		if st := c.Stats(); st.LogicalBytes != int64(len("big")+len(value)) {
+			t.Fatalf("%s: unexpected logical bytes: %d", name, st.LogicalBytes)
+		}
+		if !c.Delete("big") {
+			t.Fatalf("%s: delete failed", name)
+		}
+		if st := c.Stats(); st.UsedBytes != 0 || st.LogicalBytes != 0 {
+			t.Fatalf("%s: unexpected stats after delete: %+v", name, st)
+		}
+	}
+}
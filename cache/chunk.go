@@ -0,0 +1,46 @@
+package cache
+
+import "unsafe"
+
+// ChunkSize is the largest value kept in one slice. Longer values are stored
+// as a list of ChunkSize chunks, the last one possibly shorter, so a large
+// value never needs one big allocation.
+const ChunkSize = 64 << 10
+
+// Len returns the length of the item's value.
+func (it *Item) Len() int {
+	if it.Chunks == nil {
+		return len(it.Value)
+	}
+	n := 0
+	for _, chunk := range it.Chunks {
+		n += len(chunk)
+	}
+	return n
+}
+
+// splitChunks copies value into chunks.
+func splitChunks(value []byte) [][]byte {
+	chunks := make([][]byte, 0, (len(value)+ChunkSize-1)/ChunkSize)
+	for len(value) > 0 {
+		n := min(len(value), ChunkSize)
+		chunks = append(chunks, cloneBytes(value[:n]))
+		value = value[n:]
+	}
+	return chunks
+}
+
+// valueHeapSize estimates the heap held by a value of n bytes, chunked when
+// longer than ChunkSize.
+func valueHeapSize(n int) int64 {
+	if n <= ChunkSize {
+		return allocSize(int64(n))
+	}
+	full, rest := n/ChunkSize, n%ChunkSize
+	count := full
+	if rest > 0 {
+		count++
+	}
+	return int64(full)*allocSize(ChunkSize) + allocSize(int64(rest)) +
+		allocSize(int64(count)*int64(unsafe.Sizeof([]byte(nil))))
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+var flateReaders = sync.Pool{
+	New: func() any { return flate.NewReader(nil) },
+}
+
+// AppendValue appends the item's value to dst in one piece, joining its chunks
+// and inflating it when it is Compressed. Compressed values are raw DEFLATE
+// data, as compress/flate writes and the server stores.
+func AppendValue(dst []byte, item *Item) ([]byte, error) {
+	if !item.Compressed {
+		dst = append(dst, item.Value...)
+		for _, chunk := range item.Chunks {
+			dst = append(dst, chunk...)
+		}
+		return dst, nil
+	}
+
+	var src io.Reader = bytes.NewReader(item.Value)
+	if item.Chunks != nil {
+		readers := make([]io.Reader, len(item.Chunks))
+		for i, chunk := range item.Chunks {
+			readers[i] = bytes.NewReader(chunk)
+		}
+		src = io.MultiReader(readers...)
+	}
+	r := flateReaders.Get().(io.ReadCloser)
+	if err := r.(flate.Resetter).Reset(src, nil); err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	_, err := buf.ReadFrom(r)
+	flateReaders.Put(r)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,24 @@
+// Package cache is the in-memory store behind the utsuro server, usable on
+// its own inside a Go program.
+//
+//	c := cache.New(cache.WithMaxBytes(64 << 20))
+//	_ = c.SetTTL("user:1", 0, []byte("alice"), time.Minute)
+//	if item, ok := c.Get("user:1"); ok {
+//		fmt.Println(string(item.Value))
+//	}
+//
+// A Cache is safe for concurrent use. Every method takes one internal lock
+// for its whole duration, so each call, including the Multi variants, is
+// atomic with respect to the others. The exceptions are Resize, which
+// releases the lock between eviction batches, and GetOrLoad, which runs the
+// loader without holding it. These two can wait on work of unbounded length,
+// a long shrink or a slow loader, so they take a context that ends the wait;
+// every other method only waits for the lock, held for one in-memory
+// operation, and takes none. Items returned by Get, Peek and GetMulti are
+// copies the caller owns. Values passed to Set are copied,
+// except for chunks handed to SetChunks or SetItem, which the cache takes over.
+//
+// When used bytes would exceed the limit, writes first evict expired items
+// and then the least recently used ones until usage is back under the
+// target. Expired items are otherwise removed lazily when accessed.
+package cache
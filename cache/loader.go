@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLoaderPanicked is returned to callers waiting on a load whose loader
+// panicked.
+var ErrLoaderPanicked = errors.New("loader panicked")
+
+// maxLoadFailures is how many failures are remembered. Expired ones are swept
+// when it is reached, and the oldest is forgotten when none has expired.
+const maxLoadFailures = 1024
+
+// Loader loads the value of a key missing from the cache and says how long
+// to keep it; a ttl <= 0 means no expiration. It should return once ctx is
+// done.
+type Loader func(ctx context.Context, key string) (value []byte, ttl time.Duration, err error)
+
+type loadCall struct {
+	done chan struct{}
+	item *Item
+	err  error
+}
+
+type loadFailure struct {
+	err     error
+	expUnix int64
+}
+
+// GetOrLoad returns key's item, calling load to fetch and store it when it is
+// missing. Concurrent calls for the same missing key wait for a single load
+// and share its result. With WithNegativeTTL, a load error is returned for
+// that long without calling load again. A loaded value that cannot be stored,
+// for example because it is too large, is still returned.
+//
+// load runs with the ctx of the call that starts it. The other callers wait
+// for it until their own ctx is done, and then return ctx's error; when the
+// load fails because its ctx is done, they start another. Errors from a done
+// ctx are not remembered.
+func (c *Cache) GetOrLoad(ctx context.Context, key string, load Loader) (*Item, error) {
+	if item, ok := c.Get(key); ok {
+		return item, nil
+	}
+
+	c.loadMu.Lock()
+	if f, ok := c.loadFailures[key]; ok {
+		if f.expUnix > nowUnix() {
+			c.loadMu.Unlock()
+			return nil, f.err
+		}
+		delete(c.loadFailures, key)
+	}
+	if call, ok := c.loads[key]; ok {
+		c.loadMu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.err != nil {
+			if isContextError(call.err) && ctx.Err() == nil {
+				// The load ended with its caller's ctx, not ours; try again.
+				return c.GetOrLoad(ctx, key, load)
+			}
+			return nil, call.err
+		}
+		return cloneItem(call.item), nil
+	}
+	call := &loadCall{done: make(chan struct{}), err: ErrLoaderPanicked}
+	if c.loads == nil {
+		c.loads = make(map[string]*loadCall)
+	}
+	c.loads[key] = call
+	c.loadMu.Unlock()
+
+	defer c.finishLoad(key, call)
+	call.item, call.err = c.load(ctx, key, load)
+	if call.err != nil {
+		return nil, call.err
+	}
+	return cloneItem(call.item), nil
+}
+
+func (c *Cache) load(ctx context.Context, key string, load Loader) (*Item, error) {
+	// Another load may have finished between the miss and becoming the
+	// loader.
+	if item, ok := c.Get(key); ok {
+		return item, nil
+	}
+	value, ttl, err := load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := Item{Value: value, ExpUnix: c.expiration(ttl)}
+	if err := c.setLocked(key, item); err != nil {
+		return &item, nil
+	}
+	// Return the stored item, which has the CAS and Size the cache assigned.
+	stored, _ := c.store.get(key, false)
+	return cloneItem(&stored), nil
+}
+
+// finishLoad wakes the callers waiting on call and remembers its error.
+func (c *Cache) finishLoad(key string, call *loadCall) {
+	c.loadMu.Lock()
+	defer c.loadMu.Unlock()
+	delete(c.loads, key)
+	close(call.done)
+
+	if call.err == nil || c.negativeTTLSeconds <= 0 || isContextError(call.err) {
+		return
+	}
+	now := nowUnix()
+	if c.loadFailures == nil {
+		c.loadFailures = make(map[string]loadFailure)
+	}
+	if len(c.loadFailures) >= maxLoadFailures {
+		oldestKey, oldest := "", int64(0)
+		for k, f := range c.loadFailures {
+			if f.expUnix <= now {
+				delete(c.loadFailures, k)
+			} else if oldestKey == "" || f.expUnix < oldest {
+				oldestKey, oldest = k, f.expUnix
+			}
+		}
+		// Every failure shares the negative TTL, so the one expiring first
+		// is the oldest.
+		if len(c.loadFailures) >= maxLoadFailures {
+			delete(c.loadFailures, oldestKey)
+		}
+	}
+	c.loadFailures[key] = loadFailure{err: call.err, expUnix: now + c.negativeTTLSeconds}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
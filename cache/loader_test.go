@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoadDeduplicates(t *testing.T) {
+	c := New()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func(_ context.Context, key string) ([]byte, time.Duration, error) {
+		calls.Add(1)
+		<-release
+		return []byte("v:" + key), 0, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*Item, 50)
+	for i := range results {
+		wg.Go(func() {
+			item, err := c.GetOrLoad(t.Context(), "k", load)
+			if err != nil {
+				t.Errorf("load failed: %v", err)
+				return
+			}
+			results[i] = item
+		})
+	}
+	// Let the callers pile up on the load in flight.
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times", n)
+	}
+	stored, _ := c.Peek("k")
+	for _, item := range results {
+		if item == nil || string(item.Value) != "v:k" || item.CAS != stored.CAS || item.Size != stored.Size || item.CAS == 0 {
+			t.Fatalf("unexpected item: %+v, stored %+v", item, stored)
+		}
+	}
+	results[0].Value[0] = 'x'
+	if item, _ := c.Get("k"); string(item.Value) != "v:k" {
+		t.Fatalf("returned items share the stored value: %q", item.Value)
+	}
+}
+
+func TestGetOrLoadTTLAndNegativeCaching(t *testing.T) {
+	now := int64(100)
+	restore := SetNowUnixForTest(func() int64 { return now })
+	defer restore()
+
+	c := New(WithNegativeTTL(5 * time.Second))
+	if _, err := c.GetOrLoad(t.Context(), "k", func(context.Context, string) ([]byte, time.Duration, error) {
+		return []byte("v"), 10 * time.Second, nil
+	}); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if item, ok := c.Get("k"); !ok || item.ExpUnix != 110 {
+		t.Fatalf("unexpected stored item: %+v", item)
+	}
+
+	errBackend := errors.New("backend down")
+	calls := 0
+	failing := func(context.Context, string) ([]byte, time.Duration, error) {
+		calls++
+		return nil, 0, errBackend
+	}
+	for range 3 {
+		if _, err := c.GetOrLoad(t.Context(), "bad", failing); !errors.Is(err, errBackend) {
+			t.Fatalf("expected loader error, got: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("loader called %d times within the negative ttl", calls)
+	}
+
+	now = 105
+	item, err := c.GetOrLoad(t.Context(), "bad", func(context.Context, string) ([]byte, time.Duration, error) {
+		return []byte("ok"), 0, nil
+	})
+	if err != nil || string(item.Value) != "ok" || item.ExpUnix != 0 {
+		t.Fatalf("unexpected load after the negative ttl: %+v %v", item, err)
+	}
+}
+
+func TestGetOrLoadPanic(t *testing.T) {
+	c := New()
+	func() {
+		defer func() { _ = recover() }()
+		_, _ = c.GetOrLoad(t.Context(), "k", func(context.Context, string) ([]byte, time.Duration, error) {
+			panic("boom")
+		})
+	}()
+	// The failed load no longer blocks the key.
+	item, err := c.GetOrLoad(t.Context(), "k", func(context.Context, string) ([]byte, time.Duration, error) {
+		return []byte("v"), 0, nil
+	})
+	if err != nil || string(item.Value) != "v" {
+		t.Fatalf("unexpected load after panic: %+v %v", item, err)
+	}
+}
+
+func TestGetOrLoadCancel(t *testing.T) {
+	c := New()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	hung := func(context.Context, string) ([]byte, time.Duration, error) {
+		close(started)
+		<-release
+		return []byte("v"), 0, nil
+	}
+	loaded := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoad(context.Background(), "k", hung)
+		loaded <- err
+	}()
+	<-started
+
+	// A waiter gives up when its ctx ends, while the load goes on.
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetOrLoad(ctx, "k", hung); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got: %v", err)
+	}
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	// A load that ends with its caller's ctx is not remembered.
+	c = New(WithNegativeTTL(time.Minute))
+	ctx, cancel = context.WithCancel(t.Context())
+	cancel()
+	load := func(ctx context.Context, _ string) ([]byte, time.Duration, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		return []byte("v"), 0, nil
+	}
+	if _, err := c.GetOrLoad(ctx, "k", load); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancel error, got: %v", err)
+	}
+	item, err := c.GetOrLoad(t.Context(), "k", load)
+	if err != nil || string(item.Value) != "v" {
+		t.Fatalf("unexpected load after a cancelled one: %+v %v", item, err)
+	}
+}
+
+func TestGetOrLoadFailuresBounded(t *testing.T) {
+	c := New(WithNegativeTTL(time.Minute))
+	errBackend := errors.New("backend down")
+	failing := func(context.Context, string) ([]byte, time.Duration, error) {
+		return nil, 0, errBackend
+	}
+	for i := range maxLoadFailures * 2 {
+		if _, err := c.GetOrLoad(t.Context(), "k"+strconv.Itoa(i), failing); !errors.Is(err, errBackend) {
+			t.Fatalf("expected loader error, got: %v", err)
+		}
+	}
+	if n := len(c.loadFailures); n > maxLoadFailures {
+		t.Fatalf("%d failures remembered, want at most %d", n, maxLoadFailures)
+	}
+}
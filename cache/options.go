@@ -0,0 +1,81 @@
+package cache
+
+import "time"
+
+// DefaultMaxBytes is the memory limit of a Cache created without
+// WithMaxBytes.
+const DefaultMaxBytes = 256 * 1024 * 1024
+
+// Option configures a Cache created by New.
+type Option func(*Cache)
+
+// WithMaxBytes sets the memory limit. Values <= 0 keep DefaultMaxBytes.
+func WithMaxBytes(n int64) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.maxBytes = n
+		}
+	}
+}
+
+// WithTargetBytes sets the usage that eviction brings the cache down to once
+// it hits the limit. Values outside (0, max bytes] mean 95% of max bytes.
+func WithTargetBytes(n int64) Option {
+	return func(c *Cache) {
+		c.targetBytes = n
+	}
+}
+
+// WithEntryOverhead charges each item len(key)+len(value)+n bytes against
+// the limit instead of its estimated heap size. It is ignored with WithArena.
+func WithEntryOverhead(n int64) Option {
+	return func(c *Cache) {
+		if n >= 0 {
+			c.entryOverhead = n
+		}
+	}
+}
+
+// WithMaxEvictPerOp bounds how many items one operation may evict; the
+// default is 64.
+func WithMaxEvictPerOp(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.maxEvictPerOp = n
+		}
+	}
+}
+
+// WithIncrSlidingTTL makes successful Incr and Decr set the item to expire
+// after d, rounded up to a second. The default 0 leaves them without expiry.
+func WithIncrSlidingTTL(d time.Duration) Option {
+	return func(c *Cache) {
+		c.incrSlidingTTLSeconds = ttlSeconds(d)
+	}
+}
+
+// WithNegativeTTL makes GetOrLoad remember a loader error for d, rounded up
+// to a second, and return it without calling the loader again. The default 0
+// does not remember errors.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(c *Cache) {
+		c.negativeTTLSeconds = ttlSeconds(d)
+	}
+}
+
+// WithArena keeps keys and values in slab-allocated pages instead of one heap
+// object each, so the garbage collector has few pointers to scan. Items are
+// charged their slab chunk size.
+func WithArena() Option {
+	return func(c *Cache) {
+		c.store = newArenaStore()
+	}
+}
+
+// ttlSeconds rounds d up to whole seconds; d <= 0 is 0.
+func ttlSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
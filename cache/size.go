@@ -0,0 +1,56 @@
+package cache
+
+import "unsafe"
+
+// autoEntryOverhead, the default entry overhead, charges each entry its
+// estimated heap footprint instead of its logical size plus a fixed overhead.
+const autoEntryOverhead = -1
+
+// mapSlotOverhead approximates the per-entry cost of the items map: a string
+// key and pointer slot plus control byte, averaged over the map's load factor
+// between growths. Measured with TestHeapEstimate.
+const mapSlotOverhead = 48
+
+// sizeClasses are the Go runtime's small object size classes
+// (internal/runtime/gc/sizeclasses.go). Larger objects are rounded to pages.
+var sizeClasses = [...]int64{
+	0, 8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224, 240, 256,
+	288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896, 1024, 1152, 1280,
+	1408, 1536, 1792, 2048, 2304, 2688, 3072, 3200, 3456, 4096, 4864, 5376, 6144, 6528,
+	6784, 6912, 8192, 9472, 9728, 10240, 10880, 12288, 13568, 14336, 16384, 18432, 19072,
+	20480, 21760, 24576, 27264, 28672, 32768,
+}
+
+const pageSize = 8192
+
+// fixedEntryHeapBytes is the heap used by one entry apart from its key and
+// value bytes: the list element, lruEntry, Item and map slot.
+var fixedEntryHeapBytes = allocSize(int64(unsafe.Sizeof(listElement[*lruEntry]{}))) +
+	allocSize(int64(unsafe.Sizeof(lruEntry{}))) +
+	allocSize(int64(unsafe.Sizeof(Item{}))) +
+	mapSlotOverhead
+
+// allocSize returns the bytes the Go allocator reserves for an n byte object.
+func allocSize(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	if n > sizeClasses[len(sizeClasses)-1] {
+		return (n + pageSize - 1) / pageSize * pageSize
+	}
+	lo, hi := 1, len(sizeClasses)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if sizeClasses[mid] < n {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return sizeClasses[lo]
+}
+
+// heapEntrySize estimates the heap used by a heapStore entry.
+func heapEntrySize(keyLen, valueLen int) int64 {
+	return allocSize(int64(keyLen)) + valueHeapSize(valueLen) + fixedEntryHeapBytes
+}
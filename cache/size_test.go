@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestAllocSize(t *testing.T) {
+	tests := []struct {
+		n, want int64
+	}{
+		{0, 0},
+		{1, 8},
+		{8, 8},
+		{9, 16},
+		{33, 48},
+		{1025, 1152},
+		{32768, 32768},
+		{32769, 40960},
+	}
+	for _, tt := range tests {
+		if got := allocSize(tt.n); got != tt.want {
+			t.Errorf("allocSize(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func liveHeap() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapAlloc)
+}
+
+// TestHeapEstimate fills caches past their limit and checks that the live
+// heap they hold stays close to max bytes. RSS additionally depends on GC
+// headroom, so the live heap after a collection is what is compared.
+func TestHeapEstimate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocates tens of megabytes")
+	}
+	const maxBytes = 8 << 20
+	for _, valueSize := range []int{1, 100, 1100, 3000, 40000} {
+		t.Run(strconv.Itoa(valueSize), func(t *testing.T) {
+			before := liveHeap()
+			c := New(WithMaxBytes(maxBytes))
+			value := make([]byte, valueSize)
+			// Overshoot the limit only slightly; each eviction scans the LRU list.
+			n := int(maxBytes / heapEntrySize(len("key:0000000"), len(value)) * 11 / 10)
+			for i := 0; i < n; i++ {
+				if err := c.Set("key:"+strconv.Itoa(i), 0, value); err != nil {
+					t.Fatalf("set failed: %v", err)
+				}
+			}
+			used := liveHeap() - before
+			st := c.Stats()
+			runtime.KeepAlive(c)
+
+			if st.UsedBytes != st.HeapBytes || st.UsedBytes > maxBytes {
+				t.Fatalf("unexpected stats: %+v", st)
+			}
+			ratio := float64(used) / float64(st.HeapBytes)
+			t.Logf("items=%d logical=%d estimated=%d measured=%d ratio=%.3f", st.Items, st.LogicalBytes, st.HeapBytes, used, ratio)
+			if ratio < 0.8 || ratio > 1.25 {
+				t.Fatalf("measured heap %d is not within 25%% of estimate %d", used, st.HeapBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package cache
+
+import "strings"
+
+// store holds the items of a Cache and their LRU order. Cache does the
+// accounting and eviction policy; a store only keeps what it is given. The
+// Value of an Item returned by a store aliases its storage and is only valid
+// until the store is next modified.
+type store interface {
+	// get returns key's item, moving it to the front of the LRU when touch is
+	// set.
+	get(key string, touch bool) (Item, bool)
+	// put inserts or replaces key's item, copying the key and value, and
+	// moves it to the front of the LRU.
+	put(key string, item Item)
+	// remove deletes key and returns its item; only the length of the
+	// returned Value may be used.
+	remove(key string) (Item, bool)
+	// victim returns the least recently used expired item other than
+	// protectKey, or failing that the least recently used one.
+	victim(protectKey string, now int64) (key string, expired bool, ok bool)
+	// stableValues reports whether the Values returned by get stay
+	// unchanged after the store is modified.
+	stableValues() bool
+	len() int
+	reset()
+	// entrySize estimates the heap held by an entry of the given sizes.
+	entrySize(keyLen, valueLen int) int64
+}
+
+type lruEntry struct {
+	key  string
+	item *Item
+}
+
+// heapStore keeps each item in its own heap objects, indexed by a map and
+// ordered by a linked list.
+type heapStore struct {
+	items map[string]*listElement[*lruEntry]
+	lru   *linkedList[*lruEntry]
+}
+
+func newHeapStore() *heapStore {
+	s := &heapStore{}
+	s.reset()
+	return s
+}
+
+func (s *heapStore) get(key string, touch bool) (Item, bool) {
+	elem, ok := s.items[key]
+	if !ok {
+		return Item{}, false
+	}
+	if touch {
+		s.lru.MoveToFront(elem)
+	}
+	return *elem.Value.item, true
+}
+
+func (s *heapStore) put(key string, item Item) {
+	if item.Chunks == nil {
+		item.Value = cloneBytes(item.Value)
+	}
+	if elem, ok := s.items[key]; ok {
+		*elem.Value.item = item
+		s.lru.MoveToFront(elem)
+		return
+	}
+	// Keys often point into a larger request buffer; copy so the entry only
+	// retains what it is charged for.
+	entry := &lruEntry{key: strings.Clone(key), item: &item}
+	s.items[entry.key] = s.lru.PushFront(entry)
+}
+
+func (s *heapStore) remove(key string) (Item, bool) {
+	elem, ok := s.items[key]
+	if !ok {
+		return Item{}, false
+	}
+	delete(s.items, key)
+	s.lru.Remove(elem)
+	return *elem.Value.item, true
+}
+
+func (s *heapStore) victim(protectKey string, now int64) (string, bool, bool) {
+	var fallback *lruEntry
+	for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value
+		if entry.key == protectKey {
+			continue
+		}
+		if isExpired(entry.item, now) {
+			return entry.key, true, true
+		}
+		if fallback == nil {
+			fallback = entry
+		}
+	}
+	if fallback == nil {
+		return "", false, false
+	}
+	return fallback.key, false, true
+}
+
+// stableValues is true: put replaces an item's Value instead of writing into
+// it, so a returned Value is never modified.
+func (s *heapStore) stableValues() bool {
+	return true
+}
+
+func (s *heapStore) len() int {
+	return len(s.items)
+}
+
+func (s *heapStore) reset() {
+	s.items = make(map[string]*listElement[*lruEntry])
+	s.lru = newLinkedList[*lruEntry]()
+}
+
+func (s *heapStore) entrySize(keyLen, valueLen int) int64 {
+	return heapEntrySize(keyLen, valueLen)
+}
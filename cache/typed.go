@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/catatsuy/utsuro/codec"
+)
+
+// TypedCache stores values of type V in a Cache through a codec, recording
+// the codec's ID in each item's Flags. Get decodes with the codec the flags
+// name, so values written with another registered codec, or by another
+// program through the server, still read back.
+type TypedCache[V any] struct {
+	c     *Cache
+	codec codec.Codec
+}
+
+// NewTyped returns a TypedCache over c that encodes with cd.
+func NewTyped[V any](c *Cache, cd codec.Codec) *TypedCache[V] {
+	return &TypedCache[V]{c: c, codec: cd}
+}
+
+// Cache returns the underlying cache.
+func (t *TypedCache[V]) Cache() *Cache {
+	return t.c
+}
+
+// Get returns key's value and reports whether it was present.
+func (t *TypedCache[V]) Get(key string) (V, bool, error) {
+	var v V
+	item, ok := t.c.Get(key)
+	if !ok {
+		return v, false, nil
+	}
+	data := item.Value
+	if item.Compressed || item.Chunks != nil {
+		var err error
+		if data, err = AppendValue(nil, item); err != nil {
+			return v, true, err
+		}
+	}
+	if err := codec.Decode(item.Flags, data, &v); err != nil {
+		return v, true, err
+	}
+	return v, true, nil
+}
+
+// Set stores v under key.
+func (t *TypedCache[V]) Set(key string, v V) error {
+	return t.SetTTL(key, v, 0)
+}
+
+// SetTTL stores v under key to expire after ttl, as Cache.SetTTL.
+func (t *TypedCache[V]) SetTTL(key string, v V, ttl time.Duration) error {
+	data, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.c.SetTTL(key, t.codec.ID(), data, ttl)
+}
+
+// Delete removes key and reports whether it was present.
+func (t *TypedCache[V]) Delete(key string) bool {
+	return t.c.Delete(key)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+
+	"github.com/catatsuy/utsuro/codec"
+)
+
+type typedUser struct {
+	Name string
+	Tags []string
+}
+
+func TestTypedCache(t *testing.T) {
+	c := New()
+	users := NewTyped[typedUser](c, codec.JSON)
+
+	if _, ok, err := users.Get("u"); ok || err != nil {
+		t.Fatalf("unexpected hit: %v %v", ok, err)
+	}
+	want := typedUser{Name: "alice", Tags: []string{"a", "b"}}
+	if err := users.Set("u", want); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if item, _ := c.Get("u"); item.Flags != codec.JSONID {
+		t.Fatalf("unexpected flags: %d", item.Flags)
+	}
+	got, ok, err := users.Get("u")
+	if !ok || err != nil || got.Name != want.Name || len(got.Tags) != 2 {
+		t.Fatalf("unexpected get: %+v %v %v", got, ok, err)
+	}
+
+	// Values written with another codec are decoded by their flags.
+	if err := NewTyped[typedUser](c, codec.Gob).Set("g", want); err != nil {
+		t.Fatalf("gob set failed: %v", err)
+	}
+	if got, ok, err := users.Get("g"); !ok || err != nil || got.Name != "alice" {
+		t.Fatalf("unexpected get of gob value: %+v %v %v", got, ok, err)
+	}
+
+	if !users.Delete("u") {
+		t.Fatal("delete failed")
+	}
+}
+
+func TestTypedCacheLargeAndCompressed(t *testing.T) {
+	c := New()
+	raw := NewTyped[[]byte](c, codec.Raw)
+
+	big := bytes.Repeat([]byte("0123456789"), ChunkSize/5)
+	if err := raw.Set("big", big); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if got, ok, err := raw.Get("big"); !ok || err != nil || !bytes.Equal(got, big) {
+		t.Fatalf("unexpected chunked get: len=%d %v %v", len(got), ok, err)
+	}
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	_, _ = w.Write([]byte("compressed value"))
+	_ = w.Close()
+	if err := c.SetCompressed("z", codec.RawID, buf.Bytes()); err != nil {
+		t.Fatalf("set compressed failed: %v", err)
+	}
+	if got, ok, err := raw.Get("z"); !ok || err != nil || string(got) != "compressed value" {
+		t.Fatalf("unexpected compressed get: %q %v %v", got, ok, err)
+	}
+}
@@ -0,0 +1,465 @@
+// Package client is a Go client for utsuro. It speaks the memcached text
+// protocol subset utsuro implements, including its extensions such as incr
+// and decr creating missing keys.
+//
+// A Client is safe for concurrent use. It keeps a pool of connections per
+// server and picks the server for a key by consistent hashing, so adding or
+// removing a server moves only about 1/n of the keys.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a call whose context has no earlier deadline.
+	DefaultTimeout = time.Second
+	// DefaultMaxIdleConns is the number of idle connections kept per server.
+	DefaultMaxIdleConns = 4
+
+	// maxKeysPerGet bounds the keys sent in one get command, keeping the
+	// line well under the server's line length limit.
+	maxKeysPerGet  = 100
+	unixAddrPrefix = "unix:"
+)
+
+var (
+	ErrCacheMiss    = errors.New("utsuro: cache miss")
+	ErrNoServers    = errors.New("utsuro: no servers")
+	ErrMalformedKey = errors.New("utsuro: key is empty or contains spaces or control characters")
+	ErrClosed       = errors.New("utsuro: client closed")
+	ErrProtocol     = errors.New("utsuro: unexpected reply")
+)
+
+// ClientError is a CLIENT_ERROR reply: the server rejected the request, for
+// example because a key was too long or a value was not numeric.
+type ClientError struct {
+	Message string
+}
+
+func (e *ClientError) Error() string {
+	return "utsuro: CLIENT_ERROR " + e.Message
+}
+
+// ServerError is a SERVER_ERROR reply: the server could not carry out a
+// valid request, for example because a value was too large.
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "utsuro: SERVER_ERROR " + e.Message
+}
+
+// Item is a value and its flags. CAS is set by Gets.
+type Item struct {
+	Key   string
+	Value []byte
+	Flags uint32
+	CAS   uint64
+}
+
+// Option configures a Client created by New.
+type Option func(*Client)
+
+// WithTimeout bounds each call whose context has no earlier deadline,
+// including dialing; the default is DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// WithMaxIdleConns sets how many idle connections are kept per server.
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) {
+		if n >= 0 {
+			c.maxIdle = n
+		}
+	}
+}
+
+// WithDialer replaces net.Dialer's DialContext, for example to connect
+// through an in-memory listener.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(c *Client) {
+		c.dial = dial
+	}
+}
+
+// WithTLS connects to TCP addresses over TLS.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithAuth authenticates every new connection as user.
+func WithAuth(user, password string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// Client talks to one or more utsuro servers.
+type Client struct {
+	timeout   time.Duration
+	maxIdle   int
+	dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	tlsConfig *tls.Config
+	user      string
+	password  string
+
+	ring  *ring
+	pools map[string]*pool
+}
+
+// New returns a client for addrs, which are TCP addresses or
+// "unix:/path/to.sock". Connections are opened on first use.
+func New(addrs []string, opts ...Option) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoServers
+	}
+	c := &Client{
+		timeout: DefaultTimeout,
+		maxIdle: DefaultMaxIdleConns,
+		dial:    (&net.Dialer{}).DialContext,
+		ring:    newRing(addrs),
+		pools:   make(map[string]*pool, len(addrs)),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, addr := range addrs {
+		c.pools[addr] = &pool{}
+	}
+	return c, nil
+}
+
+// Close closes idle connections. Connections in use are closed when their
+// call returns, and later calls fail with ErrClosed.
+func (c *Client) Close() error {
+	var firstErr error
+	for _, p := range c.pools {
+		if err := p.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Get returns key's item, or ErrCacheMiss. It sends get, so the item's CAS
+// is zero; use Gets for it.
+func (c *Client) Get(ctx context.Context, key string) (*Item, error) {
+	return c.getOne(ctx, "get", key)
+}
+
+// Gets is Get with the item's CAS token set. It sends gets, which an ACL
+// may allow separately from get.
+func (c *Client) Gets(ctx context.Context, key string) (*Item, error) {
+	return c.getOne(ctx, "gets", key)
+}
+
+func (c *Client) getOne(ctx context.Context, cmd, key string) (*Item, error) {
+	if !validKey(key) {
+		return nil, ErrMalformedKey
+	}
+	var item *Item
+	err := c.withConn(ctx, c.ring.pick(key), func(cn *conn) error {
+		items, err := cn.get(cmd, []string{key})
+		item = items[key]
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrCacheMiss
+	}
+	return item, nil
+}
+
+// GetMulti returns the items found for keys. It sends one pipelined request
+// per server, in parallel; on errors it returns the items it did get.
+func (c *Client) GetMulti(ctx context.Context, keys []string) (map[string]*Item, error) {
+	byAddr := make(map[string][]string)
+	for _, key := range keys {
+		if !validKey(key) {
+			return nil, ErrMalformedKey
+		}
+		addr := c.ring.pick(key)
+		byAddr[addr] = append(byAddr[addr], key)
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		found = make(map[string]*Item, len(keys))
+		errs  []error
+	)
+	for addr, keys := range byAddr {
+		wg.Go(func() {
+			var items map[string]*Item
+			err := c.withConn(ctx, addr, func(cn *conn) error {
+				var err error
+				items, err = cn.get("get", keys)
+				return err
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			for k, v := range items {
+				found[k] = v
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			}
+		})
+	}
+	wg.Wait()
+	return found, errors.Join(errs...)
+}
+
+// Set stores item.
+func (c *Client) Set(ctx context.Context, item *Item) error {
+	if !validKey(item.Key) {
+		return ErrMalformedKey
+	}
+	return c.withConn(ctx, c.ring.pick(item.Key), func(cn *conn) error {
+		errs, err := cn.set([]*Item{item})
+		if err != nil {
+			return err
+		}
+		return errs[0]
+	})
+}
+
+// SetMulti stores items with one pipelined request per server, in parallel.
+// Items the server refused are reported in the returned error by key.
+func (c *Client) SetMulti(ctx context.Context, items []*Item) error {
+	byAddr := make(map[string][]*Item)
+	for _, item := range items {
+		if !validKey(item.Key) {
+			return ErrMalformedKey
+		}
+		addr := c.ring.pick(item.Key)
+		byAddr[addr] = append(byAddr[addr], item)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for addr, items := range byAddr {
+		wg.Go(func() {
+			var itemErrs []error
+			err := c.withConn(ctx, addr, func(cn *conn) error {
+				var err error
+				itemErrs, err = cn.set(items)
+				return err
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+				return
+			}
+			for i, err := range itemErrs {
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", items[i].Key, err))
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Delete removes key, or returns ErrCacheMiss when it is not present.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrMalformedKey
+	}
+	return c.withConn(ctx, c.ring.pick(key), func(cn *conn) error {
+		line, err := cn.roundTrip("delete " + key + "\r\n")
+		if err != nil {
+			return err
+		}
+		switch string(line) {
+		case "DELETED":
+			return nil
+		case "NOT_FOUND":
+			return ErrCacheMiss
+		}
+		return replyError(line)
+	})
+}
+
+// Incr adds delta to key's value and returns the result. A missing key is
+// created with the value delta.
+func (c *Client) Incr(ctx context.Context, key string, delta uint64) (uint64, error) {
+	return c.incrDecr(ctx, "incr", key, delta)
+}
+
+// Decr subtracts delta from key's value, stopping at 0, and returns the
+// result. A missing key is created with the value 0.
+func (c *Client) Decr(ctx context.Context, key string, delta uint64) (uint64, error) {
+	return c.incrDecr(ctx, "decr", key, delta)
+}
+
+func (c *Client) incrDecr(ctx context.Context, cmd, key string, delta uint64) (uint64, error) {
+	if !validKey(key) {
+		return 0, ErrMalformedKey
+	}
+	var v uint64
+	err := c.withConn(ctx, c.ring.pick(key), func(cn *conn) error {
+		line, err := cn.roundTrip(cmd + " " + key + " " + strconv.FormatUint(delta, 10) + "\r\n")
+		if err != nil {
+			return err
+		}
+		v, err = strconv.ParseUint(string(line), 10, 64)
+		if err != nil {
+			return replyError(line)
+		}
+		return nil
+	})
+	return v, err
+}
+
+// Ping checks that every server answers.
+func (c *Client) Ping(ctx context.Context) error {
+	var errs []error
+	for _, addr := range c.ring.addrs {
+		err := c.withConn(ctx, addr, func(cn *conn) error {
+			line, err := cn.roundTrip("version\r\n")
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(string(line), "VERSION ") {
+				return replyError(line)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withConn runs fn on a pooled connection to addr, bounded by ctx and the
+// client timeout. The connection goes back to the pool unless fn failed in
+// a way that leaves it unusable.
+func (c *Client) withConn(ctx context.Context, addr string, fn func(*conn) error) error {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	p := c.pools[addr]
+	cn, err := p.get()
+	if err != nil {
+		return err
+	}
+	if cn == nil {
+		dialCtx, cancel := context.WithDeadline(ctx, deadline)
+		cn, err = c.connect(dialCtx, addr)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		_ = cn.nc.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// Unblock reads and writes in progress.
+		_ = cn.nc.SetDeadline(time.Unix(1, 0))
+	})
+	err = fn(cn)
+	if !stop() {
+		_ = cn.nc.Close()
+		if err != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+	if resumable(err) {
+		p.put(cn, c.maxIdle)
+	} else {
+		_ = cn.nc.Close()
+	}
+	return err
+}
+
+func (c *Client) connect(ctx context.Context, addr string) (*conn, error) {
+	var nc net.Conn
+	var err error
+	if path, ok := strings.CutPrefix(addr, unixAddrPrefix); ok {
+		nc, err = c.dial(ctx, "unix", path)
+	} else {
+		nc, err = c.dial(ctx, "tcp", addr)
+		if err == nil && c.tlsConfig != nil {
+			cfg := c.tlsConfig
+			if cfg.ServerName == "" {
+				cfg = cfg.Clone()
+				cfg.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			tc := tls.Client(nc, cfg)
+			if err = tc.HandshakeContext(ctx); err != nil {
+				_ = nc.Close()
+				return nil, err
+			}
+			nc = tc
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{nc: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}
+	if c.user != "" {
+		if d, ok := ctx.Deadline(); ok {
+			_ = nc.SetDeadline(d)
+		}
+		if err := cn.auth(c.user, c.password); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// resumable reports whether a connection that returned err is still in a
+// known state.
+func resumable(err error) bool {
+	var ce *ClientError
+	var se *ServerError
+	return err == nil || errors.Is(err, ErrCacheMiss) || errors.As(err, &ce) || errors.As(err, &se)
+}
+
+// validKey reports whether key can be sent in a command line.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
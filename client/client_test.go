@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+	"github.com/catatsuy/utsuro/codec"
+	"github.com/catatsuy/utsuro/server"
+	"github.com/catatsuy/utsuro/server/utsurotest"
+)
+
+func newClient(t *testing.T, addrs []string, opts ...Option) *Client {
+	t.Helper()
+	c, err := New(addrs, opts...)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestSetGetDeleteIncr(t *testing.T) {
+	srv := utsurotest.NewServer(t)
+	c := newClient(t, []string{srv.Addr()})
+	ctx := t.Context()
+
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected ErrCacheMiss, got: %v", err)
+	}
+	if err := c.Set(ctx, &Item{Key: "k", Value: []byte("hello"), Flags: 7}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	item, err := c.Get(ctx, "k")
+	if err != nil || string(item.Value) != "hello" || item.Flags != 7 || item.CAS != 0 {
+		t.Fatalf("unexpected get: %+v %v", item, err)
+	}
+	item, err = c.Gets(ctx, "k")
+	if err != nil || string(item.Value) != "hello" || item.Flags != 7 || item.CAS == 0 {
+		t.Fatalf("unexpected gets: %+v %v", item, err)
+	}
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := c.Delete(ctx, "k"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("expected ErrCacheMiss, got: %v", err)
+	}
+
+	// utsuro creates missing counters.
+	if v, err := c.Incr(ctx, "n", 5); err != nil || v != 5 {
+		t.Fatalf("unexpected incr: %d %v", v, err)
+	}
+	if v, err := c.Decr(ctx, "n", 9); err != nil || v != 0 {
+		t.Fatalf("unexpected decr: %d %v", v, err)
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+}
+
+func TestMultiAcrossServers(t *testing.T) {
+	srvs := []*server.Server{utsurotest.NewServer(t), utsurotest.NewServer(t), utsurotest.NewServer(t)}
+	var addrs []string
+	for _, srv := range srvs {
+		addrs = append(addrs, srv.Addr())
+	}
+	c := newClient(t, addrs)
+	ctx := t.Context()
+
+	var items []*Item
+	var keys []string
+	for i := range 500 {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		items = append(items, &Item{Key: key, Value: []byte(strconv.Itoa(i))})
+	}
+	if err := c.SetMulti(ctx, items); err != nil {
+		t.Fatalf("set multi failed: %v", err)
+	}
+	for i, srv := range srvs {
+		if n := srv.Cache().Stats().Items; n < 50 {
+			t.Fatalf("server %d holds only %d items", i, n)
+		}
+	}
+
+	got, err := c.GetMulti(ctx, append(keys, "missing"))
+	if err != nil {
+		t.Fatalf("get multi failed: %v", err)
+	}
+	if len(got) != len(keys) {
+		t.Fatalf("unexpected number of items: %d", len(got))
+	}
+	for i, key := range keys {
+		if string(got[key].Value) != strconv.Itoa(i) {
+			t.Fatalf("unexpected value for %s: %q", key, got[key].Value)
+		}
+	}
+}
+
+func TestReplyErrors(t *testing.T) {
+	srv := utsurotest.NewServerConfig(t, server.Config{MaxItemSize: 16})
+	c := newClient(t, []string{srv.Addr()}, WithMaxIdleConns(1))
+	ctx := t.Context()
+
+	if err := c.Set(ctx, &Item{Key: "bad key"}); !errors.Is(err, ErrMalformedKey) {
+		t.Fatalf("expected ErrMalformedKey, got: %v", err)
+	}
+
+	if err := c.Set(ctx, &Item{Key: "s", Value: []byte("abc")}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	var ce *ClientError
+	if _, err := c.Incr(ctx, "s", 1); !errors.As(err, &ce) || !strings.Contains(ce.Message, "non-numeric") {
+		t.Fatalf("expected ClientError, got: %v", err)
+	}
+	var se *ServerError
+	if err := c.Set(ctx, &Item{Key: "big", Value: make([]byte, 17)}); !errors.As(err, &se) {
+		t.Fatalf("expected ServerError, got: %v", err)
+	}
+	err := c.SetMulti(ctx, []*Item{{Key: "a", Value: []byte("1")}, {Key: strings.Repeat("x", 251), Value: []byte("2")}})
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected ClientError, got: %v", err)
+	}
+
+	// Error replies leave the connection usable.
+	if item, err := c.Get(ctx, "a"); err != nil || string(item.Value) != "1" {
+		t.Fatalf("unexpected get: %+v %v", item, err)
+	}
+	if st := srv.Stats(); st.TotalConnections != 1 {
+		t.Fatalf("expected one connection, got: %d", st.TotalConnections)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept and never answer.
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := newClient(t, []string{ln.Addr().String()}, WithTimeout(time.Minute))
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline error, got: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("deadline was not applied")
+	}
+
+	c = newClient(t, []string{ln.Addr().String()}, WithTimeout(50*time.Millisecond))
+	if _, err := c.Get(t.Context(), "k"); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected timeout, got: %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := utsurotest.NewServerConfig(t, server.Config{Credentials: server.Credentials{"alice": "s3cret"}})
+	ctx := t.Context()
+
+	c := newClient(t, []string{srv.Addr()}, WithAuth("alice", "s3cret"))
+	if err := c.Set(ctx, &Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	c = newClient(t, []string{srv.Addr()}, WithAuth("alice", "wrong"))
+	var ce *ClientError
+	if _, err := c.Get(ctx, "k"); !errors.As(err, &ce) {
+		t.Fatalf("expected ClientError, got: %v", err)
+	}
+}
+
+func TestGetNeedsOnlyGet(t *testing.T) {
+	aclFile := t.TempDir() + "/acl"
+	if err := os.WriteFile(aclFile, []byte("alice commands=get,set\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := server.LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("load acl: %v", err)
+	}
+	srv := utsurotest.NewServerConfig(t, server.Config{
+		Credentials: server.Credentials{"alice": "s3cret"},
+		ACL:         acl,
+	})
+	ctx := t.Context()
+
+	c := newClient(t, []string{srv.Addr()}, WithAuth("alice", "s3cret"))
+	if err := c.Set(ctx, &Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if item, err := c.Get(ctx, "k"); err != nil || string(item.Value) != "v" {
+		t.Fatalf("get allowed by the ACL failed: %+v %v", item, err)
+	}
+	if items, err := c.GetMulti(ctx, []string{"k", "missing"}); err != nil || len(items) != 1 {
+		t.Fatalf("get multi allowed by the ACL failed: %v %v", items, err)
+	}
+	var ce *ClientError
+	if _, err := c.Gets(ctx, "k"); !errors.As(err, &ce) {
+		t.Fatalf("expected gets to be denied, got: %v", err)
+	}
+}
+
+func TestTypedValuesThroughServer(t *testing.T) {
+	type user struct{ Name string }
+	srv := utsurotest.NewServer(t)
+	if err := cache.NewTyped[user](srv.Cache(), codec.Gob).Set("u", user{Name: "alice"}); err != nil {
+		t.Fatalf("typed set failed: %v", err)
+	}
+
+	item, err := newClient(t, []string{srv.Addr()}).Get(t.Context(), "u")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	var got user
+	if err := codec.Decode(item.Flags, item.Value, &got); err != nil || got.Name != "alice" {
+		t.Fatalf("unexpected decode: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// pool holds the idle connections to one server.
+type pool struct {
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// get returns an idle connection, or nil when the caller should dial one.
+func (p *pool) get() (*conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	n := len(p.idle)
+	if n == 0 {
+		return nil, nil
+	}
+	cn := p.idle[n-1]
+	p.idle[n-1] = nil
+	p.idle = p.idle[:n-1]
+	return cn, nil
+}
+
+func (p *pool) put(cn *conn, maxIdle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= maxIdle {
+		_ = cn.nc.Close()
+		return
+	}
+	p.idle = append(p.idle, cn)
+}
+
+func (p *pool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var firstErr error
+	for _, cn := range p.idle {
+		if err := cn.nc.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.idle = nil
+	return firstErr
+}
+
+// conn is one connection to a server.
+type conn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+// roundTrip sends cmd and returns the reply line without its CRLF.
+func (cn *conn) roundTrip(cmd string) ([]byte, error) {
+	if _, err := cn.rw.WriteString(cmd); err != nil {
+		return nil, err
+	}
+	if err := cn.rw.Flush(); err != nil {
+		return nil, err
+	}
+	return cn.readLine()
+}
+
+// pipeline writes requests while reading their replies, so neither side
+// blocks on a full socket buffer however many requests there are.
+func (cn *conn) pipeline(write func(w *bufio.Writer) error, read func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		err := write(cn.rw.Writer)
+		if err == nil {
+			err = cn.rw.Flush()
+		}
+		errCh <- err
+	}()
+	err := read()
+	if !resumable(err) {
+		// Unblock the writer, which the server may have stopped reading.
+		_ = cn.nc.Close()
+	}
+	if werr := <-errCh; err == nil {
+		err = werr
+	}
+	return err
+}
+
+// get fetches keys with cmd, get or gets, sending up to maxKeysPerGet keys
+// per command.
+func (cn *conn) get(cmd string, keys []string) (map[string]*Item, error) {
+	items := make(map[string]*Item, len(keys))
+	batches := (len(keys) + maxKeysPerGet - 1) / maxKeysPerGet
+	err := cn.pipeline(func(w *bufio.Writer) error {
+		for i := 0; i < len(keys); i += maxKeysPerGet {
+			_, _ = w.WriteString(cmd)
+			for _, key := range keys[i:min(i+maxKeysPerGet, len(keys))] {
+				_ = w.WriteByte(' ')
+				_, _ = w.WriteString(key)
+			}
+			if _, err := w.WriteString("\r\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func() error {
+		var replyErr error
+		for range batches {
+			// An error reply ends only its own batch.
+			if err := cn.readValues(items); err != nil {
+				if !resumable(err) {
+					return err
+				}
+				replyErr = cmp.Or(replyErr, err)
+			}
+		}
+		return replyErr
+	})
+	return items, err
+}
+
+// readValues reads VALUE blocks into items up to END. The CAS field is
+// optional, as get replies omit it.
+func (cn *conn) readValues(items map[string]*Item) error {
+	for {
+		line, err := cn.readLine()
+		if err != nil {
+			return err
+		}
+		if string(line) == "END" {
+			return nil
+		}
+		fields := bytes.Fields(line)
+		if (len(fields) != 4 && len(fields) != 5) || string(fields[0]) != "VALUE" {
+			return replyError(line)
+		}
+		flags, err1 := strconv.ParseUint(string(fields[2]), 10, 32)
+		size, err2 := strconv.Atoi(string(fields[3]))
+		var cas uint64
+		var err3 error
+		if len(fields) == 5 {
+			cas, err3 = strconv.ParseUint(string(fields[4]), 10, 64)
+		}
+		if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+			return fmt.Errorf("%w: %q", ErrProtocol, line)
+		}
+		item := &Item{Key: string(fields[1]), Flags: uint32(flags), CAS: cas}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(cn.rw, buf); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return fmt.Errorf("%w: value of %q not terminated by CRLF", ErrProtocol, item.Key)
+		}
+		item.Value = buf[:size]
+		items[item.Key] = item
+	}
+}
+
+// set stores items and returns each item's outcome. The error is set when
+// the connection failed.
+func (cn *conn) set(items []*Item) ([]error, error) {
+	errs := make([]error, len(items))
+	err := cn.pipeline(func(w *bufio.Writer) error {
+		for _, item := range items {
+			if err := writeSet(w, item.Key, item.Flags, item.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func() error {
+		for i := range items {
+			line, err := cn.readLine()
+			if err != nil {
+				return err
+			}
+			if string(line) != "STORED" {
+				errs[i] = replyError(line)
+				if !resumable(errs[i]) {
+					return errs[i]
+				}
+			}
+		}
+		return nil
+	})
+	return errs, err
+}
+
+func writeSet(w *bufio.Writer, key string, flags uint32, value []byte) error {
+	_, _ = w.WriteString("set ")
+	_, _ = w.WriteString(key)
+	_ = w.WriteByte(' ')
+	_, _ = w.Write(strconv.AppendUint(w.AvailableBuffer(), uint64(flags), 10))
+	_, _ = w.WriteString(" 0 ")
+	_, _ = w.Write(strconv.AppendInt(w.AvailableBuffer(), int64(len(value)), 10))
+	_, _ = w.WriteString("\r\n")
+	_, _ = w.Write(value)
+	_, err := w.WriteString("\r\n")
+	return err
+}
+
+// auth authenticates the connection the way utsuro expects, with a set whose
+// value is "user password".
+func (cn *conn) auth(user, password string) error {
+	if err := writeSet(cn.rw.Writer, "auth", 0, []byte(user+" "+password)); err != nil {
+		return err
+	}
+	if err := cn.rw.Flush(); err != nil {
+		return err
+	}
+	line, err := cn.readLine()
+	if err != nil {
+		return err
+	}
+	if string(line) != "STORED" {
+		return replyError(line)
+	}
+	return nil
+}
+
+// readLine returns the next reply line without its CRLF. The line is only
+// valid until the next read.
+func (cn *conn) readLine() ([]byte, error) {
+	line, err := cn.rw.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+}
+
+// replyError converts an error reply, or any other unexpected line, to an
+// error.
+func replyError(line []byte) error {
+	if msg, ok := bytes.CutPrefix(line, []byte("CLIENT_ERROR ")); ok {
+		return &ClientError{Message: string(msg)}
+	}
+	if msg, ok := bytes.CutPrefix(line, []byte("SERVER_ERROR ")); ok {
+		return &ServerError{Message: string(msg)}
+	}
+	return fmt.Errorf("%w: %q", ErrProtocol, line)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// pointsPerServer is how many points each server gets on the hash ring; more
+// points spread keys more evenly.
+const pointsPerServer = 160
+
+// ring maps keys to servers by consistent hashing.
+type ring struct {
+	addrs  []string
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	addr string
+}
+
+func newRing(addrs []string) *ring {
+	r := &ring{addrs: slices.Clone(addrs)}
+	if len(addrs) == 1 {
+		return r
+	}
+	r.points = make([]ringPoint, 0, len(addrs)*pointsPerServer)
+	for _, addr := range addrs {
+		for i := range pointsPerServer {
+			r.points = append(r.points, ringPoint{hash: hashString(addr + "-" + strconv.Itoa(i)), addr: addr})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return r
+}
+
+// pick returns the server for key: the owner of the first point at or after
+// the key's hash.
+func (r *ring) pick(key string) string {
+	if len(r.points) == 0 {
+		return r.addrs[0]
+	}
+	h := hashString(key)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int {
+		return cmp.Compare(p.hash, h)
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].addr
+}
+
+// hashString is FNV-1a followed by the splitmix64 finalizer, which spreads
+// the nearly sequential point names evenly over the ring. It must never
+// change, or clients of different versions would pick different servers.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRingSpreadsAndMovesFewKeys(t *testing.T) {
+	r3 := newRing([]string{"a:1", "b:1", "c:1"})
+	r4 := newRing([]string{"a:1", "b:1", "c:1", "d:1"})
+
+	const n = 30000
+	counts := map[string]int{}
+	moved := 0
+	for i := range n {
+		key := "key" + strconv.Itoa(i)
+		addr := r3.pick(key)
+		counts[addr]++
+		if next := r4.pick(key); next != addr {
+			if next != "d:1" {
+				t.Fatalf("%s moved from %s to %s, not to the new server", key, addr, next)
+			}
+			moved++
+		}
+	}
+	for addr, c := range counts {
+		if c < n/3*7/10 || c > n/3*13/10 {
+			t.Fatalf("uneven spread: %s has %d of %d keys", addr, c, n)
+		}
+	}
+	if moved < n/4*6/10 || moved > n/4*14/10 {
+		t.Fatalf("%d of %d keys moved, want about a quarter", moved, n)
+	}
+
+	if got := newRing([]string{"only:1"}).pick("k"); got != "only:1" {
+		t.Fatalf("unexpected server: %s", got)
+	}
+}
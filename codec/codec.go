@@ -0,0 +1,177 @@
+// Package codec encodes Go values stored in utsuro. Each codec has an ID that
+// is stored in the item's flags, so a value written by one program can be
+// decoded by another that reads it back through the server, with Decode.
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// IDs of the built-in codecs. Raw is 0 so that values stored without a codec
+// read back as raw bytes.
+const (
+	RawID   uint32 = 0
+	JSONID  uint32 = 1
+	GobID   uint32 = 2
+	ProtoID uint32 = 3
+)
+
+var (
+	ErrUnknownCodec = errors.New("unknown codec")
+	ErrUnsupported  = errors.New("type not supported by codec")
+)
+
+// Codec converts values to and from bytes. Unmarshal takes a pointer to the
+// value to fill in.
+type Codec interface {
+	ID() uint32
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	Raw   Codec = rawCodec{}
+	JSON  Codec = jsonCodec{}
+	Gob   Codec = gobCodec{}
+	Proto Codec = protoCodec{}
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[uint32]Codec{
+		RawID:   Raw,
+		JSONID:  JSON,
+		GobID:   Gob,
+		ProtoID: Proto,
+	}
+)
+
+// Register makes c available to Lookup and Decode. IDs below 256 are
+// reserved for the built-in codecs.
+func Register(c Codec) error {
+	if c.ID() < 256 {
+		return fmt.Errorf("codec id %d is reserved", c.ID())
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[c.ID()]; ok {
+		return fmt.Errorf("codec id %d is already registered", c.ID())
+	}
+	registry[c.ID()] = c
+	return nil
+}
+
+// Lookup returns the codec with id.
+func Lookup(id uint32) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[id]
+	return c, ok
+}
+
+// Decode unmarshals data into v with the codec whose ID is flags.
+func Decode(flags uint32, data []byte, v any) error {
+	c, ok := Lookup(flags)
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownCodec, flags)
+	}
+	return c.Unmarshal(data, v)
+}
+
+// rawCodec stores []byte and string values as is.
+type rawCodec struct{}
+
+func (rawCodec) ID() uint32 { return RawID }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%w: raw cannot marshal %T", ErrUnsupported, v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = bytes.Clone(data)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+	return fmt.Errorf("%w: raw cannot unmarshal into %T", ErrUnsupported, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() uint32                         { return JSONID }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ID() uint32 { return GobID }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoMarshaler and ProtoUnmarshaler are the methods protobuf messages
+// generated by gogo/protobuf have; other message types can be wrapped to
+// provide them with proto.Marshal and proto.Unmarshal.
+type (
+	ProtoMarshaler interface {
+		Marshal() ([]byte, error)
+	}
+	ProtoUnmarshaler interface {
+		Unmarshal(data []byte) error
+	}
+)
+
+type protoCodec struct{}
+
+func (protoCodec) ID() uint32 { return ProtoID }
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(ProtoMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not a ProtoMarshaler", ErrUnsupported, v)
+	}
+	return m.Marshal()
+}
+
+// Unmarshal accepts a message pointer, or a pointer to a message pointer,
+// which is allocated when nil.
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(ProtoUnmarshaler); ok {
+		return m.Unmarshal(data)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Pointer {
+		elem := rv.Elem()
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		if m, ok := elem.Interface().(ProtoUnmarshaler); ok {
+			return m.Unmarshal(data)
+		}
+	}
+	return fmt.Errorf("%w: %T is not a ProtoUnmarshaler", ErrUnsupported, v)
+}
@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+// message stands in for a generated protobuf message.
+type message struct {
+	body string
+}
+
+func (m *message) Marshal() ([]byte, error) { return []byte(m.body), nil }
+
+func (m *message) Unmarshal(data []byte) error {
+	m.body = string(data)
+	return nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, Gob} {
+		data, err := c.Marshal(user{Name: "alice", Age: 30})
+		if err != nil {
+			t.Fatalf("codec %d: marshal failed: %v", c.ID(), err)
+		}
+		var got user
+		if err := Decode(c.ID(), data, &got); err != nil || got != (user{Name: "alice", Age: 30}) {
+			t.Fatalf("codec %d: unexpected decode: %+v %v", c.ID(), got, err)
+		}
+	}
+
+	data, err := Raw.Marshal("hello")
+	if err != nil {
+		t.Fatalf("raw marshal failed: %v", err)
+	}
+	var s string
+	if err := Decode(RawID, data, &s); err != nil || s != "hello" {
+		t.Fatalf("unexpected raw decode: %q %v", s, err)
+	}
+	if _, err := Raw.Marshal(1); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got: %v", err)
+	}
+
+	data, err = Proto.Marshal(&message{body: "pb"})
+	if err != nil {
+		t.Fatalf("proto marshal failed: %v", err)
+	}
+	var m *message
+	if err := Decode(ProtoID, data, &m); err != nil || m == nil || m.body != "pb" {
+		t.Fatalf("unexpected proto decode: %+v %v", m, err)
+	}
+	if _, err := Proto.Marshal(user{}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got: %v", err)
+	}
+}
+
+type upperCodec struct{}
+
+func (upperCodec) ID() uint32                    { return 1000 }
+func (upperCodec) Marshal(v any) ([]byte, error) { return Raw.Marshal(v) }
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	return Raw.Unmarshal(data, v)
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register(upperCodec{}); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if err := Register(upperCodec{}); err == nil {
+		t.Fatal("registered the same id twice")
+	}
+	if err := Register(jsonCodec{}); err == nil {
+		t.Fatal("registered a reserved id")
+	}
+	if _, ok := Lookup(1000); !ok {
+		t.Fatal("registered codec not found")
+	}
+	var s string
+	if err := Decode(999, nil, &s); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("expected ErrUnknownCodec, got: %v", err)
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"syscall"
 
-	"github.com/catatsuy/utsuro/internal/server"
+	"github.com/catatsuy/utsuro/server"
 )
 
 var Version string
@@ -54,13 +57,50 @@ func (c *CLI) Run(args []string) int {
 		return 0
 	}
 
+	creds, acl, err := loadAccessFiles(opts)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%v\n", err)
+		return 1
+	}
+
+	var adminToken string
+	if opts.adminTokenFile != "" {
+		b, err := os.ReadFile(opts.adminTokenFile)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "failed to read admin token file: %v\n", err)
+			return 1
+		}
+		adminToken = strings.TrimSpace(string(b))
+	}
+
 	logger := slog.New(slog.NewTextHandler(c.stderr, nil))
 	srv := server.NewServer(server.Config{
-		ListenAddr:            opts.listenAddr,
+		ListenAddrs:           server.SplitListenAddrs(opts.listenAddr),
+		UnixSocketPerm:        opts.unixSocketPerm,
 		MaxBytes:              opts.maxBytes,
 		TargetBytes:           opts.targetBytes,
 		MaxEvictPerOp:         opts.maxEvictPerOp,
 		IncrSlidingTTLSeconds: opts.incrSlidingTTLSeconds,
+		Storage:               opts.storage,
+		Compression:           opts.compression,
+		CompressionLevel:      opts.compressionLevel,
+		MaxLineLength:         opts.maxLineLength,
+		MaxKeyLength:          opts.maxKeyLength,
+		MaxItemSize:           opts.maxItemSize,
+		MaxConns:              opts.maxConns,
+		MetricsListenAddr:     opts.metricsListenAddr,
+		AdminListenAddr:       opts.adminListenAddr,
+		AdminToken:            adminToken,
+		IdleTimeout:           opts.idleTimeout,
+		ReadTimeout:           opts.readTimeout,
+		WriteTimeout:          opts.writeTimeout,
+		TLSCertFile:           opts.tlsCertFile,
+		TLSKeyFile:            opts.tlsKeyFile,
+		TLSCAFile:             opts.tlsCAFile,
+		TLSClientAuth:         opts.tlsClientAuth,
+		Credentials:           creds,
+		ACL:                   acl,
+		Version:               version(),
 		Verbose:               opts.verbose,
 		Logger:                logger,
 	})
@@ -68,9 +108,127 @@ func (c *CLI) Run(args []string) int {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := srv.Serve(ctx); err != nil {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe(context.Background())
+	}()
+
+wait:
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				fmt.Fprintf(c.stderr, "server failed: %v\n", err)
+				return 1
+			}
+			return 0
+		case <-hup:
+			opts = reload(srv, logger, args[1:], opts)
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(c.stderr, "graceful shutdown incomplete: %v\n", err)
+	}
+	if err := <-errCh; err != nil {
 		fmt.Fprintf(c.stderr, "server failed: %v\n", err)
 		return 1
 	}
 	return 0
 }
+
+func loadAccessFiles(opts options) (server.Credentials, *server.ACL, error) {
+	var creds server.Credentials
+	if opts.authFile != "" {
+		var err error
+		creds, err = server.LoadCredentials(opts.authFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load auth file: %w", err)
+		}
+	}
+
+	var acl *server.ACL
+	if opts.aclFile != "" {
+		var err error
+		acl, err = server.LoadACL(opts.aclFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load acl file: %w", err)
+		}
+	}
+	return creds, acl, nil
+}
+
+// reload re-reads the configuration layers, applies the reloadable settings
+// and logs what changed. On error the running configuration is kept.
+func reload(srv *server.Server, logger *slog.Logger, args []string, cur options) options {
+	next, err := parseFlags(args)
+	if err != nil {
+		logger.Error("reload failed", "error", err)
+		return cur
+	}
+	creds, acl, err := loadAccessFiles(next)
+	if err != nil {
+		logger.Error("reload failed", "error", err)
+		return cur
+	}
+
+	// Only the reloadable settings change; the others, like shutdownTimeout,
+	// keep describing the running server until it restarts.
+	applied := cur
+	applied.maxBytes = next.maxBytes
+	applied.targetBytes = next.targetBytes
+	applied.maxEvictPerOp = next.maxEvictPerOp
+	applied.incrSlidingTTLSeconds = next.incrSlidingTTLSeconds
+	applied.maxConns = next.maxConns
+	applied.verbose = next.verbose
+	applied.authFile = next.authFile
+	applied.aclFile = next.aclFile
+	applied.values = maps.Clone(cur.values)
+	for name := range reloadableFlags {
+		applied.values[name] = next.values[name]
+	}
+
+	err = srv.Reload(server.RuntimeConfig{
+		MaxBytes:              applied.maxBytes,
+		TargetBytes:           applied.targetBytes,
+		MaxEvictPerOp:         applied.maxEvictPerOp,
+		IncrSlidingTTLSeconds: applied.incrSlidingTTLSeconds,
+		MaxConns:              applied.maxConns,
+		Verbose:               applied.verbose,
+		Credentials:           creds,
+		ACL:                   acl,
+	})
+	if err != nil {
+		// Reload checks rc before changing anything, so cur still
+		// describes the running server.
+		logger.Error("reload failed", "error", err)
+		return cur
+	}
+
+	names := make([]string, 0, len(next.values))
+	for name := range next.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		from, to := cur.values[name], next.values[name]
+		if from == to {
+			continue
+		}
+		if reloadableFlags[name] {
+			logger.Info("config changed", "name", name, "from", from, "to", to)
+		} else {
+			logger.Warn("config change requires restart", "name", name, "from", from, "to", to)
+		}
+	}
+	logger.Info("config reloaded")
+	return applied
+}
@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/catatsuy/utsuro/server"
+)
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func fakeEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoadOptionsPrecedence(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "utsuro.conf", `# settings
+max-bytes = 1048576
+evict-max = 5
+max-conns = 7
+listen = "unix:/tmp/utsuro.sock"
+`)
+	env := fakeEnv(map[string]string{
+		"UTSURO_CONFIG":    path,
+		"UTSURO_EVICT_MAX": "6",
+		"UTSURO_MAX_CONNS": "8",
+	})
+
+	opt, err := loadOptions([]string{"-max-conns", "9"}, env)
+	if err != nil {
+		t.Fatalf("loadOptions failed: %v", err)
+	}
+	if opt.maxBytes != 1048576 {
+		t.Errorf("maxBytes = %d, want 1048576 from the file", opt.maxBytes)
+	}
+	if opt.listenAddr != "unix:/tmp/utsuro.sock" {
+		t.Errorf("listenAddr = %q, want the unquoted file value", opt.listenAddr)
+	}
+	if opt.maxEvictPerOp != 6 {
+		t.Errorf("maxEvictPerOp = %d, want 6 from the environment", opt.maxEvictPerOp)
+	}
+	if opt.maxConns != 9 {
+		t.Errorf("maxConns = %d, want 9 from the flag", opt.maxConns)
+	}
+	if opt.maxItemSize != server.DefaultMaxItemSize {
+		t.Errorf("maxItemSize = %d, want the default %d", opt.maxItemSize, server.DefaultMaxItemSize)
+	}
+	if opt.targetBytes != 1048576*95/100 {
+		t.Errorf("targetBytes = %d, want 95%% of maxBytes", opt.targetBytes)
+	}
+	if got := opt.values["max-conns"]; got != "9" {
+		t.Errorf("values[max-conns] = %q, want 9", got)
+	}
+
+	// A -config flag wins over UTSURO_CONFIG.
+	other := writeConfig(t, t.TempDir(), "other.conf", "max-bytes = 2097152\n")
+	opt, err = loadOptions([]string{"-config", other}, env)
+	if err != nil {
+		t.Fatalf("loadOptions failed: %v", err)
+	}
+	if opt.maxBytes != 2097152 {
+		t.Errorf("maxBytes = %d, want 2097152 from the -config file", opt.maxBytes)
+	}
+}
+
+func TestLoadOptionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		want   string
+	}{
+		{name: "unknown setting", config: "no-such-flag = 1\n", want: `unknown setting "no-such-flag"`},
+		{name: "version in file", config: "version = true\n", want: `unknown setting "version"`},
+		{name: "bad file value", config: "max-bytes = lots\n", want: "max-bytes"},
+		{name: "missing equals", config: "max-bytes\n", want: "expected name = value"},
+		{name: "bad env value", config: "", env: map[string]string{"UTSURO_MAX_CONNS": "many"}, want: "UTSURO_MAX_CONNS"},
+		{name: "bad socket perm", config: "unix-socket-perm = 1777\n", want: "-unix-socket-perm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, t.TempDir(), "utsuro.conf", tt.config)
+			_, err := loadOptions([]string{"-config", path}, fakeEnv(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadOptions([]string{"-config", filepath.Join(t.TempDir(), "missing.conf")}, fakeEnv(nil)); err == nil {
+		t.Fatal("missing config file accepted")
+	}
+}
+
+func TestReloadReportsChanges(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "utsuro.conf", "max-bytes = 1048576\nmax-conns = 5\n")
+	args := []string{"-config", path}
+	cur, err := parseFlags(args)
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	srv := server.NewServer(server.Config{MaxBytes: cur.maxBytes, MaxConns: cur.maxConns})
+	defer srv.Close()
+
+	writeConfig(t, dir, "utsuro.conf", "max-bytes = 1048576\nmax-conns = 10\nidle-timeout = 5s\n")
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	got := reload(srv, logger, args, cur)
+
+	out := logs.String()
+	for _, want := range []string{
+		`msg="config changed" name=max-conns from=5 to=10`,
+		`msg="config change requires restart" name=idle-timeout from=0s to=5s`,
+		`msg="config reloaded"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "name=max-bytes") {
+		t.Errorf("unchanged max-bytes logged:\n%s", out)
+	}
+	if got.maxConns != 10 || got.values["max-conns"] != "10" {
+		t.Errorf("maxConns = %d (%q), want 10", got.maxConns, got.values["max-conns"])
+	}
+	if got.idleTimeout != 0 || got.values["idle-timeout"] != "0s" {
+		t.Errorf("idleTimeout = %v (%q), want the running 0s", got.idleTimeout, got.values["idle-timeout"])
+	}
+	if n := srv.Stats().MaxConnections; n != 10 {
+		t.Errorf("server max connections = %d, want 10", n)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "utsuro.conf", "max-conns = 5\n")
+	args := []string{"-config", path}
+	cur, err := parseFlags(args)
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	srv := server.NewServer(server.Config{MaxConns: cur.maxConns})
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "bad value", config: "max-conns = many\n"},
+		{name: "missing acl file", config: "max-conns = 10\nacl-file = " + filepath.Join(dir, "missing") + "\n"},
+		{name: "unknown acl command", config: "max-conns = 10\nacl-file = " + writeConfig(t, dir, "acl", "alice commands=nosuchcmd\n") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfig(t, dir, "utsuro.conf", tt.config)
+			var logs bytes.Buffer
+			got := reload(srv, slog.New(slog.NewTextHandler(&logs, nil)), args, cur)
+			if !strings.Contains(logs.String(), `msg="reload failed"`) {
+				t.Errorf("log lacks the failure:\n%s", logs.String())
+			}
+			if strings.Contains(logs.String(), "config changed") {
+				t.Errorf("failed reload logged a change:\n%s", logs.String())
+			}
+			if got.maxConns != 5 || got.aclFile != "" {
+				t.Errorf("reload returned maxConns=%d aclFile=%q, want the running config", got.maxConns, got.aclFile)
+			}
+			if n := srv.Stats().MaxConnections; n != 5 {
+				t.Errorf("server max connections = %d, want 5", n)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := envName("incr-sliding-ttl-seconds"); got != "UTSURO_INCR_SLIDING_TTL_SECONDS" {
+		t.Fatalf("envName = %q", got)
+	}
+}
@@ -1,35 +1,212 @@
 package cli
 
-import "flag"
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/catatsuy/utsuro/server"
+)
 
 type options struct {
 	listenAddr            string
+	unixSocketPerm        os.FileMode
 	maxBytes              int64
 	targetBytes           int64
 	maxEvictPerOp         int
 	incrSlidingTTLSeconds int64
+	storage               string
+	compression           []server.CompressionRule
+	compressionLevel      int
+	maxLineLength         int
+	maxKeyLength          int
+	maxItemSize           int
+	maxConns              int
+	idleTimeout           time.Duration
+	readTimeout           time.Duration
+	writeTimeout          time.Duration
+	shutdownTimeout       time.Duration
+	metricsListenAddr     string
+	adminListenAddr       string
+	adminTokenFile        string
+	tlsCertFile           string
+	tlsKeyFile            string
+	tlsCAFile             string
+	tlsClientAuth         string
+	authFile              string
+	aclFile               string
 	verbose               bool
 	showVersion           bool
+	configFile            string
+
+	// values holds every setting by flag name after layering, for diffing
+	// on reload.
+	values map[string]string
+}
+
+// envPrefix prefixes the environment variable of each flag, e.g.
+// UTSURO_MAX_BYTES for -max-bytes.
+const envPrefix = "UTSURO_"
+
+// reloadableFlags are applied by SIGHUP without a restart. The auth and ACL
+// files are re-read on every reload even when their paths are unchanged.
+var reloadableFlags = map[string]bool{
+	"max-bytes":                true,
+	"target-bytes":             true,
+	"evict-max":                true,
+	"incr-sliding-ttl-seconds": true,
+	"max-conns":                true,
+	"verbose":                  true,
+	"auth-file":                true,
+	"acl-file":                 true,
 }
 
 func parseFlags(args []string) (options, error) {
+	return loadOptions(args, os.LookupEnv)
+}
+
+// loadOptions layers settings with increasing precedence: defaults, the
+// -config file, UTSURO_* environment variables and command line flags.
+func loadOptions(args []string, lookupEnv func(string) (string, bool)) (options, error) {
 	opt := options{}
 	fs := flag.NewFlagSet("utsuro", flag.ContinueOnError)
-	fs.StringVar(&opt.listenAddr, "listen", "127.0.0.1:11211", "TCP address to listen on")
-	fs.Int64Var(&opt.maxBytes, "max-bytes", 256*1024*1024, "max logical bytes")
+	fs.StringVar(&opt.listenAddr, "listen", "127.0.0.1:11211", "comma-separated TCP addresses or unix:/path/to.sock to listen on")
+	unixSocketPerm := fs.String("unix-socket-perm", "0700", "permission bits (octal) for unix sockets")
+	fs.Int64Var(&opt.maxBytes, "max-bytes", 256*1024*1024, "max estimated heap bytes used by items")
 	fs.Int64Var(&opt.targetBytes, "target-bytes", 0, "eviction target bytes")
 	fs.IntVar(&opt.maxEvictPerOp, "evict-max", 64, "max evictions per operation")
 	fs.Int64Var(&opt.incrSlidingTTLSeconds, "incr-sliding-ttl-seconds", 0, "sliding TTL in seconds for successful incr/decr; 0 disables")
+	fs.StringVar(&opt.storage, "storage", server.StorageHeap, "item storage engine: heap or arena (slab-allocated pages, less GC work)")
+	compression := fs.String("compress", "", "compress values with flate by key prefix: comma-separated prefix=min-size or prefix=off, * for all keys; empty disables")
+	fs.IntVar(&opt.compressionLevel, "compress-level", 1, "flate compression level, 1 (fastest) to 9 (smallest)")
+	fs.IntVar(&opt.maxLineLength, "max-line-length", server.DefaultMaxLineLength, "max command line length in bytes")
+	fs.IntVar(&opt.maxKeyLength, "max-key-length", server.DefaultMaxKeyLength, "max key length in bytes")
+	fs.IntVar(&opt.maxItemSize, "max-item-size", server.DefaultMaxItemSize, "max value size in bytes")
+	fs.IntVar(&opt.maxConns, "max-conns", 1024, "max simultaneous connections; 0 means unlimited")
+	fs.DurationVar(&opt.idleTimeout, "idle-timeout", 0, "close connections idle between commands for this long; 0 disables")
+	fs.DurationVar(&opt.readTimeout, "read-timeout", 0, "max time to receive a command and its payload; 0 disables")
+	fs.DurationVar(&opt.writeTimeout, "write-timeout", 0, "max time to send a reply before the client is dropped as slow; 0 disables")
+	fs.DurationVar(&opt.shutdownTimeout, "shutdown-timeout", 10*time.Second, "max time to drain connections on SIGTERM")
+	fs.StringVar(&opt.metricsListenAddr, "metrics-listen", "", "address serving Prometheus metrics at /metrics; empty disables")
+	fs.StringVar(&opt.adminListenAddr, "admin-listen", "", "address serving the admin HTTP API; empty disables")
+	fs.StringVar(&opt.adminTokenFile, "admin-token-file", "", "file holding the bearer token required by the admin HTTP API")
+	fs.StringVar(&opt.tlsCertFile, "tls-cert", "", "TLS certificate file (PEM); enables TLS on TCP listeners")
+	fs.StringVar(&opt.tlsKeyFile, "tls-key", "", "TLS private key file (PEM)")
+	fs.StringVar(&opt.tlsCAFile, "tls-ca", "", "CA bundle (PEM) used to verify client certificates")
+	fs.StringVar(&opt.tlsClientAuth, "tls-client-auth", "", "client certificate policy: none, request, require, verify-if-given, require-and-verify (default require-and-verify with -tls-ca, otherwise none)")
+	fs.StringVar(&opt.authFile, "auth-file", "", "file of username:password lines; requires clients to authenticate")
+	fs.StringVar(&opt.aclFile, "acl-file", "", "file restricting commands and key prefixes per user")
 	fs.BoolVar(&opt.verbose, "verbose", false, "verbose logging")
 	fs.BoolVar(&opt.showVersion, "version", false, "print version and exit")
+	fs.StringVar(&opt.configFile, "config", "", "file of name=value settings using flag names; UTSURO_* environment variables and flags take precedence")
 
 	if err := fs.Parse(args); err != nil {
 		return options{}, err
 	}
 
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if !explicit["config"] {
+		if v, ok := lookupEnv(envName("config")); ok {
+			opt.configFile = v
+		}
+	}
+	var fileValues map[string]string
+	if opt.configFile != "" {
+		var err error
+		fileValues, err = loadConfigFile(opt.configFile)
+		if err != nil {
+			return options{}, err
+		}
+		for name := range fileValues {
+			if name == "config" || name == "version" || fs.Lookup(name) == nil {
+				return options{}, fmt.Errorf("%s: unknown setting %q", opt.configFile, name)
+			}
+		}
+	}
+
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil || explicit[f.Name] || f.Name == "config" || f.Name == "version" {
+			return
+		}
+		if v, ok := lookupEnv(envName(f.Name)); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				setErr = fmt.Errorf("%s: %w", envName(f.Name), err)
+			}
+			return
+		}
+		if v, ok := fileValues[f.Name]; ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				setErr = fmt.Errorf("%s: %s: %w", opt.configFile, f.Name, err)
+			}
+		}
+	})
+	if setErr != nil {
+		return options{}, setErr
+	}
+
+	opt.values = map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { opt.values[f.Name] = f.Value.String() })
+
+	perm, err := strconv.ParseUint(*unixSocketPerm, 8, 32)
+	if err != nil || perm > 0o777 {
+		return options{}, fmt.Errorf("invalid -unix-socket-perm: %q", *unixSocketPerm)
+	}
+	opt.unixSocketPerm = os.FileMode(perm)
+
+	opt.compression, err = server.ParseCompressionRules(*compression)
+	if err != nil {
+		return options{}, err
+	}
+
 	if opt.targetBytes <= 0 {
 		opt.targetBytes = opt.maxBytes * 95 / 100
 	}
 
 	return opt, nil
 }
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfigFile reads "name = value" lines. Blank lines and lines starting
+// with '#' are ignored, and a value may be wrapped in double quotes.
+func loadConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, lineNo)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// aclCommandGroups maps the names usable in an ACL "commands=" list to the
+// protocol commands they allow. A bare command name, including one registered
+// with Server.Handle, is accepted too.
+var aclCommandGroups = map[string][]string{
+	"read":  {"get", "gets"},
+	"write": {"set", "delete"},
+	"incr":  {"incr", "decr"},
+	"admin": {"cache_memlimit"},
+}
+
+// aclDefaultUser is the rule applied to principals without their own line,
+// including unauthenticated connections.
+const aclDefaultUser = "*"
+
+// ACL restricts the commands and key prefixes each user may use.
+type ACL struct {
+	rules map[string]aclRule
+}
+
+type aclRule struct {
+	// commands is nil when every command is allowed. Otherwise it maps the
+	// commands the list names to whether they are allowed, and allCommands
+	// decides for the commands it does not name.
+	commands    map[string]bool
+	allCommands bool
+	// prefixes is nil when every key is allowed.
+	prefixes []string
+}
+
+// LoadACL reads an ACL file. Each non-comment line is
+//
+//	<user> [commands=<list>] [prefixes=<list>]
+//
+// where lists are comma-separated. Commands are group names (read, write,
+// incr, admin), command names, including those registered with Server.Handle,
+// or "all"; an entry prefixed with '-' removes it again, e.g.
+// "commands=all,-incr". Omitting commands= or prefixes= allows everything;
+// an empty prefix is an error, and "*" allows every key.
+// The user "*" applies to everyone without their own line. Command names are
+// checked against the server's commands when the ACL is put to use.
+func LoadACL(path string) (*ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseACL(f)
+}
+
+func parseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{rules: map[string]aclRule{}}
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		user := fields[0]
+		if _, dup := acl.rules[user]; dup {
+			return nil, fmt.Errorf("line %d: duplicate user %q", lineNo, user)
+		}
+
+		var rule aclRule
+		for _, field := range fields[1:] {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected name=value, got %q", lineNo, field)
+			}
+			switch name {
+			case "commands":
+				rule.commands, rule.allCommands = parseACLCommands(value)
+			case "prefixes":
+				rule.prefixes = []string{}
+				for _, p := range strings.Split(value, ",") {
+					// An empty prefix would match every key.
+					if p == "" {
+						return nil, fmt.Errorf("line %d: empty entry in prefixes=%q", lineNo, value)
+					}
+					if p == "*" {
+						rule.prefixes = nil
+						break
+					}
+					rule.prefixes = append(rule.prefixes, p)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown field %q", lineNo, name)
+			}
+		}
+		acl.rules[user] = rule
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func parseACLCommands(value string) (cmds map[string]bool, all bool) {
+	cmds = map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry == "" {
+			continue
+		}
+		allow := true
+		if rest, ok := strings.CutPrefix(entry, "-"); ok {
+			allow = false
+			entry = rest
+		}
+
+		if entry == "all" {
+			all = allow
+			clear(cmds)
+			continue
+		}
+		names := aclCommandGroups[entry]
+		if names == nil {
+			names = []string{strings.ToLower(entry)}
+		}
+		for _, name := range names {
+			cmds[name] = allow
+		}
+	}
+	return cmds, all
+}
+
+// validate returns an error when a commands= list names a command that known
+// does not report, which is most likely a typo.
+func (a *ACL) validate(known func(cmd string) bool) error {
+	for user, rule := range a.rules {
+		for cmd := range rule.commands {
+			if !known(cmd) {
+				return fmt.Errorf("acl for %q: unknown command or group %q", user, cmd)
+			}
+		}
+	}
+	return nil
+}
+
+// check reports whether user may run cmd on keys. When a key is refused it is
+// returned as deniedKey.
+func (a *ACL) check(user, cmd string, keys []string) (ok bool, deniedKey string) {
+	rule, found := a.rules[user]
+	if !found {
+		rule, found = a.rules[aclDefaultUser]
+		if !found {
+			return false, ""
+		}
+	}
+	if rule.commands != nil {
+		allowed, named := rule.commands[cmd]
+		if !named {
+			allowed = rule.allCommands
+		}
+		if !allowed {
+			return false, ""
+		}
+	}
+	if rule.prefixes == nil {
+		return true, ""
+	}
+	for _, key := range keys {
+		if !hasAnyPrefix(key, rule.prefixes) {
+			return false, key
+		}
+	}
+	return true, ""
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkACL refuses commands the ACL does not allow, writing an audit log
+// entry. Every command but version is checked, including those registered
+// with Handle, whose keys are checked against prefixes through Command.Keys.
+func (s *Server) checkACL(next Handler) Handler {
+	return HandlerFunc(func(w *ResponseWriter, req *Request) error {
+		acl := s.acl()
+		if acl == nil || req.Command == "version" {
+			return next.ServeCommand(w, req)
+		}
+		user := req.Conn.Principal()
+		ok, deniedKey := acl.check(user, req.Command, req.Keys())
+		if ok {
+			return next.ServeCommand(w, req)
+		}
+
+		s.logger.Warn("acl denied",
+			"user", user,
+			"remote", req.Conn.RemoteAddr,
+			"cmd", req.Command,
+			"key", deniedKey,
+		)
+		return req.Reject(w, "access denied")
+	})
+}
+
+// validateACL checks that acl names only commands the server has.
+func (s *Server) validateACL(acl *ACL) error {
+	if acl == nil {
+		return nil
+	}
+	return acl.validate(func(cmd string) bool { return s.command(cmd) != nil })
+}
+
+func (s *Server) acl() *ACL {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.aclRules
+}
+
+func discardPayload(r *bufio.Reader, n int) error {
+	if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+		return err
+	}
+	return consumeChunkTerminator(r)
+}
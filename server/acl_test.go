@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	acl, err := parseACL(strings.NewReader(`
+# read-only service limited to its namespace
+reader commands=read prefixes=app1:,shared:
+counter commands=all,-write prefixes=ctr:
+* commands=read prefixes=public:
+`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	tests := []struct {
+		user string
+		cmd  string
+		keys []string
+		ok   bool
+	}{
+		{"reader", "get", []string{"app1:a", "shared:b"}, true},
+		{"reader", "get", []string{"app1:a", "other:b"}, false},
+		{"reader", "set", []string{"app1:a"}, false},
+		{"counter", "incr", []string{"ctr:hits"}, true},
+		{"counter", "delete", []string{"ctr:hits"}, false},
+		{"anonymous", "gets", []string{"public:x"}, true},
+		{"", "get", []string{"app1:a"}, false},
+	}
+	for _, tt := range tests {
+		ok, _ := acl.check(tt.user, tt.cmd, tt.keys)
+		if ok != tt.ok {
+			t.Errorf("check(%q, %q, %v) = %v, want %v", tt.user, tt.cmd, tt.keys, ok, tt.ok)
+		}
+	}
+
+	for _, bad := range []string{"u prefixes", "u x=1", "u\nu", "u prefixes=", "u prefixes=a,,b", "u prefixes=a,"} {
+		if _, err := parseACL(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestACLEnforcement(t *testing.T) {
+	acl, err := parseACL(strings.NewReader("alice commands=read,write prefixes=a:\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	var logs bytes.Buffer
+	conn, stop := newPipeSessionConfig(t, Config{
+		MaxBytes:    1 << 20,
+		Credentials: Credentials{"alice": "pw", "bob": "pw"},
+		ACL:         acl,
+		Logger:      slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	defer stop()
+
+	resp := sendCommand(t, conn, "set auth 0 0 8\r\nalice pw\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected auth response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "set a:k 0 0 1\r\nv\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected allowed set response: %q", resp)
+	}
+
+	// The denied payload must be swallowed so the next command parses.
+	resp = sendCommand(t, conn, "set b:k 0 0 3\r\nxyz\r\n", "\r\n")
+	if resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected denied set response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "incr a:n 1\r\n", "\r\n")
+	if resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected denied incr response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "get a:k\r\n", "END\r\n")
+	if resp != "VALUE a:k 0 1\r\nv\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+
+	if !strings.Contains(logs.String(), "acl denied") || !strings.Contains(logs.String(), "key=b:k") {
+		t.Fatalf("denial not audited: %s", logs.String())
+	}
+}
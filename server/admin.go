@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+// adminItem is the JSON form of an item returned by GET /keys/{key}. Size is
+// the stored value length, which is the compressed length when Compressed is
+// set, Bytes the accounted size, and TTLSeconds is -1 when the item does not
+// expire.
+type adminItem struct {
+	Key        string `json:"key"`
+	Flags      uint32 `json:"flags"`
+	Size       int    `json:"size"`
+	Bytes      int64  `json:"bytes"`
+	CAS        uint64 `json:"cas"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+	ExpUnix    int64  `json:"exp_unix"`
+	Compressed bool   `json:"compressed,omitempty"`
+}
+
+// adminConfig is the JSON form of GET /config. Secrets are left out.
+type adminConfig struct {
+	ListenAddrs           []string `json:"listen_addrs"`
+	MaxBytes              int64    `json:"max_bytes"`
+	TargetBytes           int64    `json:"target_bytes"`
+	MaxEvictPerOp         int      `json:"evict_max"`
+	IncrSlidingTTLSeconds int64    `json:"incr_sliding_ttl_seconds"`
+	Storage               string   `json:"storage"`
+	MaxLineLength         int      `json:"max_line_length"`
+	MaxKeyLength          int      `json:"max_key_length"`
+	MaxItemSize           int      `json:"max_item_size"`
+	MaxConns              int      `json:"max_conns"`
+	IdleTimeout           string   `json:"idle_timeout"`
+	ReadTimeout           string   `json:"read_timeout"`
+	WriteTimeout          string   `json:"write_timeout"`
+	TLS                   bool     `json:"tls"`
+	TLSClientAuth         string   `json:"tls_client_auth,omitempty"`
+	Auth                  bool     `json:"auth"`
+	ACL                   bool     `json:"acl"`
+	MetricsListenAddr     string   `json:"metrics_listen_addr,omitempty"`
+	AdminListenAddr       string   `json:"admin_listen_addr"`
+	Version               string   `json:"version"`
+}
+
+func (s *Server) adminMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", s.handleAdminReady)
+	mux.Handle("GET /keys/{key}", s.requireAdminToken(http.HandlerFunc(s.handleAdminGetKey)))
+	mux.Handle("DELETE /keys/{key}", s.requireAdminToken(http.HandlerFunc(s.handleAdminDeleteKey)))
+	mux.Handle("POST /flush", s.requireAdminToken(http.HandlerFunc(s.handleAdminFlush)))
+	mux.Handle("GET /config", s.requireAdminToken(http.HandlerFunc(s.handleAdminConfig)))
+	return mux
+}
+
+// requireAdminToken rejects requests without "Authorization: Bearer <token>".
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	want := []byte(s.cfg.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(want) == 0 || subtle.ConstantTimeCompare([]byte(token), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="utsuro"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleAdminReady(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-s.Ready():
+	default:
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if s.shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *Server) handleAdminGetKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	var item *cache.Item
+	var ok bool
+	if p, isPeek := s.cache.(peekBackend); isPeek {
+		item, ok = p.Peek(key)
+	} else {
+		item, ok = s.cache.Get(key)
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	ttl := int64(-1)
+	if item.ExpUnix > 0 {
+		ttl = max(item.ExpUnix-time.Now().Unix(), 0)
+	}
+	writeJSON(w, adminItem{
+		Key:        key,
+		Flags:      item.Flags,
+		Size:       item.Len(),
+		Bytes:      item.Size,
+		CAS:        item.CAS,
+		TTLSeconds: ttl,
+		ExpUnix:    item.ExpUnix,
+		Compressed: item.Compressed,
+	})
+}
+
+func (s *Server) handleAdminDeleteKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !s.cache.Delete(key) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	s.logger.Info("admin delete", "key", key, "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminFlush(w http.ResponseWriter, r *http.Request) {
+	s.cache.Flush()
+	s.logger.Info("admin flush", "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfg
+	cs := s.cache.Stats()
+	version := cfg.Version
+	if version == "" {
+		version = "(devel)"
+	}
+	storage := cfg.Storage
+	if storage == "" {
+		storage = StorageHeap
+	}
+	writeJSON(w, adminConfig{
+		ListenAddrs:           s.Addrs(),
+		MaxBytes:              cs.MaxBytes,
+		TargetBytes:           cs.TargetBytes,
+		MaxEvictPerOp:         cs.MaxEvictPerOp,
+		IncrSlidingTTLSeconds: int64(cs.IncrSlidingTTL / time.Second),
+		Storage:               storage,
+		MaxLineLength:         cfg.MaxLineLength,
+		MaxKeyLength:          cfg.MaxKeyLength,
+		MaxItemSize:           cfg.MaxItemSize,
+		MaxConns:              s.MaxConns(),
+		IdleTimeout:           cfg.IdleTimeout.String(),
+		ReadTimeout:           cfg.ReadTimeout.String(),
+		WriteTimeout:          cfg.WriteTimeout.String(),
+		TLS:                   s.tlsEnabled(),
+		TLSClientAuth:         cfg.TLSClientAuth,
+		Auth:                  s.authRequired(),
+		ACL:                   s.acl() != nil,
+		MetricsListenAddr:     s.MetricsAddr(),
+		AdminListenAddr:       s.AdminAddr(),
+		Version:               version,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// AdminAddr returns the address of the admin endpoint, or "" when it is
+// disabled or not yet listening.
+func (s *Server) AdminAddr() string {
+	return s.httpAddr("admin")
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, method, url, token string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestAdminAPI(t *testing.T) {
+	srv, stop := startServer(t, Config{
+		ListenAddr:      "127.0.0.1:0",
+		AdminListenAddr: "127.0.0.1:0",
+		AdminToken:      "t0ken",
+		MaxBytes:        1 << 20,
+	})
+	defer stop()
+	base := "http://" + srv.AdminAddr()
+
+	roundTrip(t, "tcp", srv.Addr(), "set k 42 0 3\r\nabc\r\n", "\r\n")
+
+	if resp, _ := adminRequest(t, "GET", base+"/keys/k", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("missing token: status %d", resp.StatusCode)
+	}
+	if resp, _ := adminRequest(t, "GET", base+"/keys/k", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status %d", resp.StatusCode)
+	}
+
+	resp, body := adminRequest(t, "GET", base+"/keys/k", "t0ken")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /keys/k: status %d", resp.StatusCode)
+	}
+	var item adminItem
+	if err := json.Unmarshal(body, &item); err != nil {
+		t.Fatalf("decode item: %v", err)
+	}
+	if item.Key != "k" || item.Flags != 42 || item.Size != 3 || item.CAS == 0 || item.TTLSeconds != -1 {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+
+	resp, body = adminRequest(t, "GET", base+"/config", "t0ken")
+	var cfg adminConfig
+	if err := json.Unmarshal(body, &cfg); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /config: status %d err %v", resp.StatusCode, err)
+	}
+	if cfg.MaxBytes != 1<<20 || cfg.MaxEvictPerOp != 64 || cfg.AdminListenAddr != srv.AdminAddr() {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	// Reloaded settings are reported as they now apply.
+	if err := srv.Reload(RuntimeConfig{MaxBytes: 1 << 20, MaxEvictPerOp: 7, IncrSlidingTTLSeconds: 30}); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	cfg = adminConfig{}
+	if _, body = adminRequest(t, "GET", base+"/config", "t0ken"); json.Unmarshal(body, &cfg) != nil {
+		t.Fatalf("decode config: %s", body)
+	}
+	if cfg.MaxEvictPerOp != 7 || cfg.IncrSlidingTTLSeconds != 30 {
+		t.Fatalf("stale config after reload: %+v", cfg)
+	}
+
+	if resp, _ := adminRequest(t, "DELETE", base+"/keys/k", "t0ken"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE /keys/k: status %d", resp.StatusCode)
+	}
+	if resp, _ := adminRequest(t, "DELETE", base+"/keys/k", "t0ken"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("second DELETE /keys/k: status %d", resp.StatusCode)
+	}
+
+	roundTrip(t, "tcp", srv.Addr(), "set k2 0 0 1\r\nv\r\n", "\r\n")
+	if resp, _ := adminRequest(t, "POST", base+"/flush", "t0ken"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /flush: status %d", resp.StatusCode)
+	}
+	if got := roundTrip(t, "tcp", srv.Addr(), "get k2\r\n", "END\r\n"); got != "END\r\n" {
+		t.Fatalf("flush did not remove items: %q", got)
+	}
+
+	if resp, _ := adminRequest(t, "GET", base+"/healthz", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz: status %d", resp.StatusCode)
+	}
+	if resp, _ := adminRequest(t, "GET", base+"/readyz", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /readyz: status %d", resp.StatusCode)
+	}
+}
+
+func TestAdminReadyDuringShutdown(t *testing.T) {
+	srv, stop := startServer(t, Config{
+		ListenAddr:      "127.0.0.1:0",
+		AdminListenAddr: "127.0.0.1:0",
+		AdminToken:      "t0ken",
+		MaxBytes:        1 << 20,
+	})
+	defer stop()
+	base := "http://" + srv.AdminAddr()
+
+	// A half-sent set keeps the drain going.
+	conn := dialServer(t, srv.Addr())
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("set k 0 0 5\r\nab")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	waitFor(t, func() bool { return srv.Stats().CurrConnections == 1 })
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+	waitFor(t, func() bool { return srv.shuttingDown.Load() })
+
+	if resp, _ := adminRequest(t, "GET", base+"/readyz", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("GET /readyz while draining: status %d", resp.StatusCode)
+	}
+	if resp, _ := adminRequest(t, "GET", base+"/healthz", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz while draining: status %d", resp.StatusCode)
+	}
+
+	if _, err := conn.Write([]byte("cde\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Fatal("admin endpoint still serving after shutdown")
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	err := NewServer(Config{ListenAddr: "127.0.0.1:0", AdminListenAddr: "127.0.0.1:0"}).ListenAndServe(t.Context())
+	if err == nil {
+		t.Fatal("expected error without admin token")
+	}
+}
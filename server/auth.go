@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Credentials maps usernames to passwords. A nil Credentials disables
+// authentication.
+type Credentials map[string]string
+
+// LoadCredentials reads a memcached-style auth file: one "username:password"
+// per line. Blank lines and lines starting with '#' are ignored.
+func LoadCredentials(path string) (Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCredentials(f)
+}
+
+func parseCredentials(r io.Reader) (Credentials, error) {
+	creds := Credentials{}
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, pass, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected username:password", lineNo)
+		}
+		creds[user] = pass
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// verify compares sha256 digests of the passwords, which have the same length
+// whatever the passwords are, so the time taken reveals neither the password
+// length nor, since unknown users are compared too, which users exist.
+func (c Credentials) verify(user, pass string) bool {
+	want, ok := c[user]
+	wantSum := sha256.Sum256([]byte(want))
+	passSum := sha256.Sum256([]byte(pass))
+	match := subtle.ConstantTimeCompare(wantSum[:], passSum[:]) == 1
+	return ok && match
+}
+
+// requireAuth lets only set, which authenticates, and version through on
+// unauthenticated connections when authentication is enabled.
+func (s *Server) requireAuth(next Handler) Handler {
+	return HandlerFunc(func(w *ResponseWriter, req *Request) error {
+		if req.Conn.User != "" || !s.authRequired() {
+			return next.ServeCommand(w, req)
+		}
+		switch req.Command {
+		case "set":
+			return s.handleAuthSet(req.Reader, w, req.Args, req.Conn)
+		case "version":
+			return next.ServeCommand(w, req)
+		default:
+			return req.Reject(w, "unauthenticated")
+		}
+	})
+}
+
+// handleAuthSet implements memcached's text protocol authentication: the value
+// of a set on an unauthenticated connection is "username password", and the
+// key, flags and exptime are ignored.
+func (s *Server) handleAuthSet(r *bufio.Reader, w *ResponseWriter, args []string, info *ConnInfo) error {
+	_, _, bytesN, err := parseSetArgs(args)
+	if err != nil {
+		return writeClientError(w, err.Error())
+	}
+
+	value, err := s.readValue(r, bytesN, false)
+	if err != nil {
+		return writeValueError(w, err)
+	}
+
+	user, pass, _ := bytes.Cut(value, []byte(" "))
+	if !s.credentials().verify(string(user), string(pass)) {
+		s.logf("authentication failure from %s user=%q", info.RemoteAddr, user)
+		return writeDenied(w, "authentication failure")
+	}
+	info.User = string(user)
+	s.logf("authenticated %s user=%q", info.RemoteAddr, info.User)
+	w.result = resultStored
+
+	_, err = w.WriteString("STORED\r\n")
+	return err
+}
+
+func (s *Server) credentials() Credentials {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.creds
+}
+
+func (s *Server) authRequired() bool {
+	return s.credentials() != nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	creds, err := parseCredentials(strings.NewReader("# comment\n\nalice:s3cret\nbob:pa:ss\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if !creds.verify("alice", "s3cret") {
+		t.Fatal("alice should verify")
+	}
+	if !creds.verify("bob", "pa:ss") {
+		t.Fatal("password may contain colons")
+	}
+	if creds.verify("alice", "wrong") || creds.verify("carol", "") {
+		t.Fatal("unexpected verification success")
+	}
+
+	if _, err := parseCredentials(strings.NewReader("nocolon\n")); err == nil {
+		t.Fatal("expected error for malformed line")
+	}
+}
+
+func TestTextProtocolAuth(t *testing.T) {
+	conn, stop := newPipeSessionConfig(t, Config{
+		MaxBytes:    1 << 20,
+		Credentials: Credentials{"alice": "s3cret"},
+		Version:     "1.2.3",
+	})
+	defer stop()
+
+	resp := sendCommand(t, conn, "get k\r\n", "\r\n")
+	if resp != "CLIENT_ERROR unauthenticated\r\n" {
+		t.Fatalf("unexpected unauthenticated get response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "version\r\n", "\r\n")
+	if resp != "VERSION 1.2.3\r\n" {
+		t.Fatalf("unexpected version response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "set auth 0 0 11\r\nalice wrong\r\n", "\r\n")
+	if resp != "CLIENT_ERROR authentication failure\r\n" {
+		t.Fatalf("unexpected bad password response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "set auth 0 0 12\r\nalice s3cret\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected auth response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "set k 0 0 1\r\nv\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "get auth k\r\n", "END\r\n")
+	if resp != "VALUE k 0 1\r\nv\r\nEND\r\n" {
+		t.Fatalf("auth set must not store a value: %q", resp)
+	}
+}
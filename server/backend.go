@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+var errResizeUnsupported = errors.New("backend does not support resizing")
+
+// Backend stores the items the protocol handlers serve. *cache.Cache is the
+// default implementation, and the methods mean the same as its methods, but
+// any store can implement it, for example a tiered or read-through store, or
+// a fake that injects errors in tests.
+//
+// Get returns an item the server only reads, until the reply is written.
+// SetItem receives the flags and, for values the server compressed or split,
+// the Compressed and Chunks fields in item; a backend must store them as
+// given and return them from Get. Chunks are handed over and not reused by the
+// server.
+//
+// SetItem, Incr and Decr may return cache.ErrObjectTooLarge and
+// cache.ErrNoSpace, which are reported as SERVER_ERROR, and Incr and Decr
+// cache.ErrNonNumeric and cache.ErrOverflow, which are reported as
+// CLIENT_ERROR. Any other error is a SERVER_ERROR internal error.
+type Backend interface {
+	Get(key string) (*cache.Item, bool)
+	SetItem(key string, item cache.Item) error
+	Delete(key string) bool
+	Incr(key string, delta uint64) (uint64, error)
+	Decr(key string, delta uint64) (uint64, error)
+	Flush()
+	Stats() cache.Stats
+}
+
+// ViewBackend is a Backend that can look up several keys at once without
+// copying their values, as cache.Cache.ViewMulti does. Gets use it when the
+// backend implements it.
+type ViewBackend interface {
+	Backend
+	ViewMulti(keys []string, hits []cache.Hit, buf *[]byte) []cache.Hit
+}
+
+// TunableBackend is a Backend whose limits can change at runtime, for
+// cache_memlimit and Reload. Without it cache_memlimit fails and Reload
+// leaves the memory settings alone.
+type TunableBackend interface {
+	Backend
+	Resize(ctx context.Context, maxBytes, targetBytes int64) error
+	SetMaxEvictPerOp(n int)
+	SetIncrSlidingTTL(d time.Duration)
+}
+
+// peekBackend is a Backend that can look up a key without counting it as
+// used, which the admin API prefers.
+type peekBackend interface {
+	Peek(key string) (*cache.Item, bool)
+}
+
+var (
+	_ ViewBackend    = (*cache.Cache)(nil)
+	_ TunableBackend = (*cache.Cache)(nil)
+	_ peekBackend    = (*cache.Cache)(nil)
+)
+
+// getMulti looks up keys one by one for backends without ViewMulti.
+func getMulti(b Backend, keys []string, hits []cache.Hit) []cache.Hit {
+	for i, key := range keys {
+		if item, ok := b.Get(key); ok {
+			hits = append(hits, cache.Hit{Index: i, Item: *item})
+		}
+	}
+	return hits
+}
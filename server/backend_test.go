@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+// faultyBackend fails every Set with err. Embedding only the Backend
+// interface hides the cache's tuning methods.
+type faultyBackend struct {
+	Backend
+	err error
+}
+
+func (b *faultyBackend) SetItem(key string, item cache.Item) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.Backend.SetItem(key, item)
+}
+
+// mapBackend is a Backend written from scratch, without the cache package's
+// fast paths.
+type mapBackend struct {
+	mu    sync.Mutex
+	items map[string]cache.Item
+	cas   uint64
+}
+
+func (b *mapBackend) Get(key string) (*cache.Item, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item, ok := b.items[key]
+	return &item, ok
+}
+
+func (b *mapBackend) SetItem(key string, item cache.Item) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cas++
+	item.CAS = b.cas
+	b.items[key] = item
+	return nil
+}
+
+func (b *mapBackend) Delete(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.items[key]
+	delete(b.items, key)
+	return ok
+}
+
+func (b *mapBackend) Incr(key string, delta uint64) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, err := strconv.ParseUint(string(b.items[key].Value), 10, 64)
+	if _, ok := b.items[key]; ok && err != nil {
+		return 0, cache.ErrNonNumeric
+	}
+	n += delta
+	b.cas++
+	b.items[key] = cache.Item{Value: strconv.AppendUint(nil, n, 10), CAS: b.cas}
+	return n, nil
+}
+
+func (b *mapBackend) Decr(key string, delta uint64) (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (b *mapBackend) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.items)
+}
+
+func (b *mapBackend) Stats() cache.Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return cache.Stats{Items: int64(len(b.items))}
+}
+
+func TestCustomBackend(t *testing.T) {
+	backend := &faultyBackend{Backend: cache.New()}
+	srv := NewServer(Config{Backend: backend})
+	if srv.Cache() != nil || srv.Backend() != backend {
+		t.Fatal("server does not use the custom backend")
+	}
+
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+
+	if resp := sendCommand(t, conn, "set a 0 0 1\r\nx\r\n", "\r\n"); resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "get a\r\n", "END\r\n"); resp != "VALUE a 0 1\r\nx\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+
+	backend.err = cache.ErrNoSpace
+	if resp := sendCommand(t, conn, "set a 0 0 1\r\ny\r\n", "\r\n"); resp != "SERVER_ERROR out of memory\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	backend.err = errors.New("disk full")
+	if resp := sendCommand(t, conn, "set a 0 0 1\r\ny\r\n", "\r\n"); resp != "SERVER_ERROR internal error\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+
+	if resp := sendCommand(t, conn, "cache_memlimit 1\r\n", "\r\n"); resp != "CLIENT_ERROR backend does not support resizing\r\n" {
+		t.Fatalf("unexpected cache_memlimit response: %q", resp)
+	}
+	if err := srv.Reload(RuntimeConfig{MaxBytes: 1 << 20}); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+}
+
+func TestBackendWithoutCache(t *testing.T) {
+	backend := &mapBackend{items: map[string]cache.Item{}}
+	srv := NewServer(Config{
+		Backend:     backend,
+		Compression: []CompressionRule{{Prefix: "z:", MinSize: 1}},
+	})
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+
+	if resp := sendCommand(t, conn, "set a 5 0 1\r\nx\r\n", "\r\n"); resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "gets a missing\r\n", "END\r\n"); resp != "VALUE a 5 1 1\r\nx\r\nEND\r\n" {
+		t.Fatalf("unexpected gets response: %q", resp)
+	}
+
+	// Compressed and chunked values round trip through the item fields.
+	for key, value := range map[string]string{"z:small": strings.Repeat("abc", 1000), "big": strings.Repeat("abc", 40000)} {
+		resp := sendCommand(t, conn, fmt.Sprintf("set %s 0 0 %d\r\n%s\r\n", key, len(value), value), "\r\n")
+		if resp != "STORED\r\n" {
+			t.Fatalf("unexpected set %s response: %q", key, resp)
+		}
+		want := fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\nEND\r\n", key, len(value), value)
+		if resp := sendCommand(t, conn, "get "+key+"\r\n", "END\r\n"); resp != want {
+			t.Fatalf("unexpected get %s response of %d bytes", key, len(resp))
+		}
+	}
+	if !backend.items["z:small"].Compressed || backend.items["big"].Chunks == nil {
+		t.Fatalf("values not stored compressed and chunked")
+	}
+
+	if resp := sendCommand(t, conn, "incr n 3\r\n", "\r\n"); resp != "3\r\n" {
+		t.Fatalf("unexpected incr response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "delete a\r\n", "\r\n"); resp != "DELETED\r\n" {
+		t.Fatalf("unexpected delete response: %q", resp)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+func benchmarkGet(b *testing.B, storage string, size int) {
+	srv := NewServer(Config{MaxBytes: 64 << 20, Storage: storage})
+	if err := srv.Cache().Set("k", 0, make([]byte, size)); err != nil {
+		b.Fatal(err)
+	}
+	w := &ResponseWriter{Writer: bufio.NewWriter(io.Discard)}
+	args := []string{"k"}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	for b.Loop() {
+		if err := srv.handleGetLike(w, args, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet1KB(b *testing.B) {
+	b.Run("heap", func(b *testing.B) { benchmarkGet(b, StorageHeap, 1<<10) })
+	b.Run("arena", func(b *testing.B) { benchmarkGet(b, StorageArena, 1<<10) })
+}
+
+func BenchmarkGet1MB(b *testing.B) {
+	b.Run("heap", func(b *testing.B) { benchmarkGet(b, StorageHeap, 1<<20) })
+	b.Run("arena", func(b *testing.B) { benchmarkGet(b, StorageArena, 1<<20) })
+}
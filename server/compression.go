@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+// CompressionRule compresses values of keys starting with Prefix that are at
+// least MinSize bytes long. A negative MinSize disables compression for the
+// prefix.
+type CompressionRule struct {
+	Prefix  string
+	MinSize int
+}
+
+// ParseCompressionRules parses comma-separated "prefix=min-size" rules, where
+// min-size may be "off" and the prefix "*" matches every key. For example
+// "*=1024,json:=256,img:=off".
+func ParseCompressionRules(s string) ([]CompressionRule, error) {
+	var rules []CompressionRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.LastIndexByte(part, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid compression rule %q: expected prefix=min-size", part)
+		}
+		prefix, size := part[:i], part[i+1:]
+		if prefix == "*" {
+			prefix = ""
+		}
+		rule := CompressionRule{Prefix: prefix, MinSize: -1}
+		if size != "off" {
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid compression rule %q: bad min-size", part)
+			}
+			rule.MinSize = n
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compressor compresses values with flate according to rules matched by
+// longest prefix, and keeps statistics.
+type compressor struct {
+	rules   []CompressionRule
+	writers sync.Pool
+
+	inputBytes     atomic.Int64
+	outputBytes    atomic.Int64
+	storedRaw      atomic.Int64
+	compressNs     atomic.Int64
+	decompressNs   atomic.Int64
+	decompressions atomic.Int64
+}
+
+func newCompressor(rules []CompressionRule, level int) *compressor {
+	if level == 0 {
+		level = flate.BestSpeed
+	}
+	c := &compressor{rules: append([]CompressionRule(nil), rules...)}
+	sort.SliceStable(c.rules, func(i, j int) bool {
+		return len(c.rules[i].Prefix) > len(c.rules[j].Prefix)
+	})
+	c.writers.New = func() any {
+		w, err := flate.NewWriter(nil, level)
+		if err != nil {
+			// Levels are validated by the caller.
+			w, _ = flate.NewWriter(nil, flate.BestSpeed)
+		}
+		return w
+	}
+	return c
+}
+
+func (c *compressor) enabled() bool {
+	return c != nil && len(c.rules) > 0
+}
+
+// compress returns the value, given as value or as chunks, compressed and
+// split into cache.ChunkSize chunks, or nil when no rule asks for it or it
+// would not get smaller.
+func (c *compressor) compress(key string, value []byte, chunks [][]byte) [][]byte {
+	size := len(value)
+	for _, chunk := range chunks {
+		size += len(chunk)
+	}
+	if !c.enabled() || !c.wants(key, size) {
+		return nil
+	}
+
+	start := time.Now()
+	out := chunkWriter{limit: size}
+	w := c.writers.Get().(*flate.Writer)
+	w.Reset(&out)
+	_, err := w.Write(value)
+	for _, chunk := range chunks {
+		if err != nil {
+			break
+		}
+		_, err = w.Write(chunk)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	c.writers.Put(w)
+	c.compressNs.Add(int64(time.Since(start)))
+
+	if err != nil {
+		c.storedRaw.Add(1)
+		return nil
+	}
+	c.inputBytes.Add(int64(size))
+	c.outputBytes.Add(int64(out.n))
+	return out.finish()
+}
+
+var errNotSmaller = errors.New("compressed value is not smaller")
+
+// chunkWriter collects written bytes in cache.ChunkSize chunks, failing with
+// errNotSmaller once limit bytes have been written.
+type chunkWriter struct {
+	chunks [][]byte
+	n      int
+	limit  int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) >= w.limit {
+		return 0, errNotSmaller
+	}
+	w.n += len(p)
+	written := len(p)
+	for len(p) > 0 {
+		last := len(w.chunks) - 1
+		if last < 0 || len(w.chunks[last]) == cache.ChunkSize {
+			w.chunks = append(w.chunks, make([]byte, 0, min(w.limit, cache.ChunkSize)))
+			last++
+		}
+		k := min(len(p), cache.ChunkSize-len(w.chunks[last]))
+		w.chunks[last] = append(w.chunks[last], p[:k]...)
+		p = p[k:]
+	}
+	return written, nil
+}
+
+// finish returns the chunks, trimming the spare capacity of the last one.
+func (w *chunkWriter) finish() [][]byte {
+	if last := len(w.chunks) - 1; last >= 0 && cap(w.chunks[last]) > len(w.chunks[last]) {
+		w.chunks[last] = bytes.Clone(w.chunks[last])
+	}
+	return w.chunks
+}
+
+func (c *compressor) wants(key string, size int) bool {
+	for _, rule := range c.rules {
+		if strings.HasPrefix(key, rule.Prefix) {
+			return rule.MinSize >= 0 && size >= rule.MinSize
+		}
+	}
+	return false
+}
+
+// decompress appends the decompressed value of item to dst.
+func (c *compressor) decompress(dst []byte, item *cache.Item) ([]byte, error) {
+	start := time.Now()
+	dst, err := cache.AppendValue(dst, item)
+	c.decompressNs.Add(int64(time.Since(start)))
+	c.decompressions.Add(1)
+	return dst, err
+}
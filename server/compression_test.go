@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseCompressionRules(t *testing.T) {
+	rules, err := ParseCompressionRules("*=1024, doc:=256,img:=off,")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	want := []CompressionRule{{"", 1024}, {"doc:", 256}, {"img:", -1}}
+	if fmt.Sprint(rules) != fmt.Sprint(want) {
+		t.Fatalf("rules = %v, want %v", rules, want)
+	}
+	for _, bad := range []string{"doc:", "a=-1", "a=big"} {
+		if _, err := ParseCompressionRules(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	srv := NewServer(Config{
+		MaxBytes:    1 << 20,
+		Compression: []CompressionRule{{"", 512}, {"raw:", -1}},
+	})
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+
+	doc := strings.Repeat(`{"id":1,"name":"utsuro","tags":["a","b"]},`, 50)
+	for _, key := range []string{"doc", "raw:doc"} {
+		resp := sendCommand(t, conn, fmt.Sprintf("set %s 5 0 %d\r\n%s\r\n", key, len(doc), doc), "\r\n")
+		if resp != "STORED\r\n" {
+			t.Fatalf("unexpected set response: %q", resp)
+		}
+	}
+	random := make([]byte, 1000)
+	_, _ = rand.Read(random)
+	resp := sendCommand(t, conn, fmt.Sprintf("set noise 0 0 %d\r\n%s\r\n", len(random), random), "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "get doc raw:doc\r\n", "END\r\n")
+	want := fmt.Sprintf("VALUE doc 5 %d\r\n%s\r\nVALUE raw:doc 5 %d\r\n%s\r\nEND\r\n", len(doc), doc, len(doc), doc)
+	if resp != want {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+
+	if item, _ := srv.Cache().Peek("doc"); !item.Compressed || len(item.Value) >= len(doc)/5 {
+		t.Fatalf("doc not compressed: %d bytes", len(item.Value))
+	}
+	if item, _ := srv.Cache().Peek("raw:doc"); item.Compressed {
+		t.Fatal("raw:doc should not be compressed")
+	}
+	if item, _ := srv.Cache().Peek("noise"); item.Compressed {
+		t.Fatal("incompressible value should be stored raw")
+	}
+
+	var b strings.Builder
+	if err := srv.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		fmt.Sprintf("utsuro_compression_input_bytes_total %d\n", len(doc)),
+		"utsuro_compression_skipped_total 1\n",
+		"utsuro_decompressions_total 1\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Fatalf("metrics missing %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestCorruptCompressedValueIsMiss(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 20})
+	if err := srv.Cache().SetCompressed("bad", 0, []byte("not flate")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := srv.Cache().Set("good", 0, []byte("v")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+
+	if resp := sendCommand(t, conn, "get bad good\r\n", "END\r\n"); resp != "VALUE good 0 1\r\nv\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+	if hits, misses := srv.metrics.getHits.Load(), srv.metrics.getMisses.Load(); hits != 1 || misses != 1 {
+		t.Fatalf("hits = %d, misses = %d, want 1 and 1", hits, misses)
+	}
+}
+
+func TestCompressionOfChunkedValues(t *testing.T) {
+	for _, storage := range []string{StorageHeap, StorageArena} {
+		srv := NewServer(Config{
+			MaxBytes:    4 << 20,
+			Storage:     storage,
+			Compression: []CompressionRule{{"", 512}},
+		})
+		conn, stop := newPipeSessionServer(t, srv)
+
+		doc := strings.Repeat(`{"id":1,"name":"utsuro","tags":["a","b"]},`, 4500)
+		// Words drawn at random compress less well, so the compressed value
+		// still takes several chunks.
+		random := make([]byte, 100000)
+		_, _ = rand.Read(random)
+		var words strings.Builder
+		for _, b := range random {
+			fmt.Fprintf(&words, "word%d ", b%64)
+		}
+		for key, value := range map[string]string{"doc": doc, "words": words.String()} {
+			resp := sendCommand(t, conn, fmt.Sprintf("set %s 0 0 %d\r\n%s\r\n", key, len(value), value), "\r\n")
+			if resp != "STORED\r\n" {
+				t.Fatalf("%s: unexpected set response: %q", storage, resp)
+			}
+			item, _ := srv.Cache().Peek(key)
+			if !item.Compressed || item.Len() >= len(value)*2/3 {
+				t.Fatalf("%s: %s not compressed: %d of %d bytes", storage, key, item.Len(), len(value))
+			}
+			resp = sendCommand(t, conn, "get "+key+"\r\n", "END\r\n")
+			if want := fmt.Sprintf("VALUE %s 0 %d\r\n%s\r\nEND\r\n", key, len(value), value); resp != want {
+				t.Fatalf("%s: unexpected get %s response of %d bytes", storage, key, len(resp))
+			}
+		}
+		if item, _ := srv.Cache().Peek("words"); len(item.Chunks) < 2 {
+			t.Fatalf("%s: compressed words stored in %d chunks", storage, len(item.Chunks))
+		}
+		stop()
+	}
+}
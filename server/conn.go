@@ -0,0 +1,524 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+var (
+	errLineTooLong  = errors.New("line too long")
+	errKeyTooLong   = errors.New("key too long")
+	errItemTooLarge = errors.New("object too large for cache")
+	errBadDataChunk = errors.New("bad data chunk")
+)
+
+// ConnInfo describes the peer of a client connection.
+type ConnInfo struct {
+	RemoteAddr string
+	// TLSIdentity is the client certificate identity; empty without mTLS.
+	// TLSVerified reports whether the certificate was verified.
+	TLSIdentity string
+	TLSVerified bool
+	// User is set once the connection has authenticated.
+	User string
+}
+
+// Principal is the name used for authorization: the authenticated user, or
+// else the verified client certificate identity.
+func (i *ConnInfo) Principal() string {
+	if i.User != "" {
+		return i.User
+	}
+	if i.TLSVerified {
+		return i.TLSIdentity
+	}
+	return ""
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	// The connection counts as idle until the handshake is done, so Shutdown
+	// closes a peer that is slow to send its ClientHello.
+	tc := &timeoutConn{Conn: conn, s: s, idle: true}
+	if !s.trackConn(tc) {
+		return
+	}
+	defer s.untrackConn(tc)
+
+	info := ConnInfo{RemoteAddr: remoteAddrString(conn)}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		_ = tlsConn.SetDeadline(deadline(s.handshakeTimeout()))
+		if err := tlsConn.Handshake(); err != nil {
+			s.logf("tls handshake error from %s: %v", info.RemoteAddr, err)
+			return
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		info.TLSIdentity = peerIdentity(state)
+		info.TLSVerified = len(state.VerifiedChains) > 0
+		if info.TLSIdentity != "" {
+			s.logf("tls client %s identity=%q", info.RemoteAddr, info.TLSIdentity)
+		}
+	}
+
+	r := bufio.NewReader(tc)
+	w := &ResponseWriter{Writer: bufio.NewWriter(tc)}
+	h := s.rootHandler()
+	req := &Request{Conn: &info, Reader: r, server: s}
+
+	for {
+		if !tc.beginIdle() {
+			return
+		}
+		if _, err := r.Peek(1); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				s.logf("read error: %v", err)
+			}
+			return
+		}
+		if !tc.beginCommand() {
+			return
+		}
+
+		line, err := readCommandLine(r, s.cfg.MaxLineLength)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				// The rest of the line may be followed by a payload we cannot
+				// frame, so the connection is closed rather than resynced.
+				s.logf("line too long from %s", info.RemoteAddr)
+				_ = writeClientError(w, "line too long")
+				_ = w.Flush()
+				return
+			}
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				s.logf("read error: %v", err)
+			}
+			return
+		}
+
+		parsed, err := parseLine(line)
+		if err != nil {
+			_ = writeClientError(w, "bad command line format")
+			if flushErr := w.Flush(); flushErr != nil {
+				return
+			}
+			continue
+		}
+		if parsed.isQuit {
+			return
+		}
+
+		req.Command, req.Args, req.Start = parsed.cmd, parsed.args, time.Now()
+		w.result = ""
+		err = h.ServeCommand(w, req)
+		s.metrics.observe(req.Command, w.result, time.Since(req.Start))
+		if err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+		w.release()
+	}
+}
+
+func (s *Server) handleGetLike(w *ResponseWriter, args []string, withCAS bool) error {
+	if len(args) == 0 {
+		return writeClientError(w, "get requires at least one key")
+	}
+	if err := s.checkKeys(args); err != nil {
+		return writeClientError(w, err.Error())
+	}
+
+	if s.view != nil {
+		w.hits = s.view.ViewMulti(args, w.hits[:0], &w.valueBuf)
+	} else {
+		w.hits = getMulti(s.cache, args, w.hits[:0])
+	}
+	// A value that fails to decompress is left out of the reply and counted
+	// as a miss.
+	hits := len(w.hits)
+	for _, hit := range w.hits {
+		if hit.Item.Compressed {
+			var err error
+			w.inflateBuf, err = s.compressor.decompress(w.inflateBuf[:0], &hit.Item)
+			if err != nil {
+				s.logger.Error("decompress failed", "key", args[hit.Index], "error", err)
+				hits--
+				continue
+			}
+			hit.Item.Value, hit.Item.Chunks = w.inflateBuf, nil
+		}
+		if err := writeValueHeader(w, args[hit.Index], hit.Item, withCAS); err != nil {
+			return err
+		}
+		if _, err := w.Write(hit.Item.Value); err != nil {
+			return err
+		}
+		for _, chunk := range hit.Item.Chunks {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	s.metrics.getHits.Add(uint64(hits))
+	s.metrics.getMisses.Add(uint64(len(args) - hits))
+	w.result = resultOK
+	_, err := w.WriteString("END\r\n")
+	return err
+}
+
+// writeValueHeader writes "VALUE <key> <flags> <bytes> [<cas>]\r\n" without
+// the allocations of fmt. bufio.Writer errors are sticky, so checking the last
+// write is enough.
+func writeValueHeader(w *ResponseWriter, key string, item cache.Item, withCAS bool) error {
+	_, _ = w.WriteString("VALUE ")
+	_, _ = w.WriteString(key)
+	_ = w.WriteByte(' ')
+	_, _ = w.Write(strconv.AppendUint(w.AvailableBuffer(), uint64(item.Flags), 10))
+	_ = w.WriteByte(' ')
+	_, _ = w.Write(strconv.AppendInt(w.AvailableBuffer(), int64(item.Len()), 10))
+	if withCAS {
+		_ = w.WriteByte(' ')
+		_, _ = w.Write(strconv.AppendUint(w.AvailableBuffer(), item.CAS, 10))
+	}
+	_, err := w.WriteString("\r\n")
+	return err
+}
+
+func (s *Server) handleSet(r *bufio.Reader, w *ResponseWriter, args []string) error {
+	key, flags, bytesN, err := parseSetArgs(args)
+	if err != nil {
+		return writeClientError(w, err.Error())
+	}
+	keyErr := s.checkKeys(args[:1])
+
+	var value []byte
+	var chunks [][]byte
+	if bytesN > cache.ChunkSize && keyErr == nil {
+		chunks, err = s.readChunks(r, bytesN)
+	} else {
+		value, err = s.readValue(r, bytesN, keyErr != nil)
+	}
+	if err != nil {
+		return writeValueError(w, err)
+	}
+	if keyErr != nil {
+		return writeClientError(w, keyErr.Error())
+	}
+
+	item := cache.Item{Value: value, Chunks: chunks, Flags: flags}
+	if packed := s.compressor.compress(key, value, chunks); packed != nil {
+		item.Value, item.Chunks, item.Compressed = nil, packed, true
+		if len(packed) == 1 {
+			item.Value, item.Chunks = packed[0], nil
+		}
+	}
+	if err := s.cache.SetItem(key, item); err != nil {
+		if errors.Is(err, cache.ErrObjectTooLarge) || errors.Is(err, cache.ErrNoSpace) {
+			return writeServerError(w, err.Error())
+		}
+		return writeServerError(w, "internal error")
+	}
+
+	w.result = resultStored
+	_, err = w.WriteString("STORED\r\n")
+	return err
+}
+
+func (s *Server) handleDelete(w *ResponseWriter, args []string) error {
+	if len(args) != 1 {
+		return writeClientError(w, "delete requires key")
+	}
+	if err := s.checkKeys(args); err != nil {
+		return writeClientError(w, err.Error())
+	}
+	if s.cache.Delete(args[0]) {
+		w.result = resultDeleted
+		_, err := w.WriteString("DELETED\r\n")
+		return err
+	}
+	w.result = resultNotFound
+	_, err := w.WriteString("NOT_FOUND\r\n")
+	return err
+}
+
+func (s *Server) handleIncrDecr(w *ResponseWriter, args []string, incr bool) error {
+	key, delta, err := parseDeltaArgs(args)
+	if err != nil {
+		return writeClientError(w, err.Error())
+	}
+	if err := s.checkKeys(args[:1]); err != nil {
+		return writeClientError(w, err.Error())
+	}
+
+	var value uint64
+	if incr {
+		value, err = s.cache.Incr(key, delta)
+	} else {
+		value, err = s.cache.Decr(key, delta)
+	}
+	if err != nil {
+		if errors.Is(err, cache.ErrNonNumeric) {
+			return writeClientError(w, cache.ErrNonNumeric.Error())
+		}
+		if errors.Is(err, cache.ErrOverflow) {
+			return writeClientError(w, cache.ErrOverflow.Error())
+		}
+		if errors.Is(err, cache.ErrObjectTooLarge) || errors.Is(err, cache.ErrNoSpace) {
+			return writeServerError(w, err.Error())
+		}
+		return writeServerError(w, "internal error")
+	}
+
+	w.result = resultOK
+	_, err = fmt.Fprintf(w, "%d\r\n", value)
+	return err
+}
+
+// handleCacheMemlimit implements memcached's "cache_memlimit <megabytes>",
+// extended with an optional eviction target in megabytes.
+func (s *Server) handleCacheMemlimit(w *ResponseWriter, args []string) error {
+	maxBytes, targetBytes, err := parseMemlimitArgs(args)
+	if err != nil {
+		return writeClientError(w, err.Error())
+	}
+	if err := s.Resize(maxBytes, targetBytes); err != nil {
+		return writeClientError(w, err.Error())
+	}
+	w.result = resultOK
+	_, err = w.WriteString("OK\r\n")
+	return err
+}
+
+func (s *Server) handleVersion(w *ResponseWriter) error {
+	v := s.cfg.Version
+	if v == "" {
+		v = "(devel)"
+	}
+	w.result = resultOK
+	_, err := fmt.Fprintf(w, "VERSION %s\r\n", v)
+	return err
+}
+
+func (s *Server) checkKeys(keys []string) error {
+	for _, key := range keys {
+		if len(key) > s.cfg.MaxKeyLength {
+			return errKeyTooLong
+		}
+	}
+	return nil
+}
+
+// readValue reads a set payload of n bytes and its terminator. Payloads
+// larger than MaxItemSize, or any payload when discard is true, are swallowed
+// without being buffered so the connection stays in sync.
+func (s *Server) readValue(r *bufio.Reader, n int, discard bool) ([]byte, error) {
+	if n > s.cfg.MaxItemSize || discard {
+		if err := discardPayload(r, n); err != nil {
+			return nil, payloadError(err)
+		}
+		if discard {
+			return nil, nil
+		}
+		return nil, errItemTooLarge
+	}
+
+	value := make([]byte, n)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, payloadError(err)
+	}
+	if err := consumeChunkTerminator(r); err != nil {
+		return nil, payloadError(err)
+	}
+	return value, nil
+}
+
+// readChunks is readValue for values longer than cache.ChunkSize, reading
+// them straight into chunks.
+func (s *Server) readChunks(r *bufio.Reader, n int) ([][]byte, error) {
+	if n > s.cfg.MaxItemSize {
+		_, err := s.readValue(r, n, false)
+		return nil, err
+	}
+
+	chunks := make([][]byte, 0, (n+cache.ChunkSize-1)/cache.ChunkSize)
+	for n > 0 {
+		chunk := make([]byte, min(n, cache.ChunkSize))
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, payloadError(err)
+		}
+		chunks = append(chunks, chunk)
+		n -= len(chunk)
+	}
+	if err := consumeChunkTerminator(r); err != nil {
+		return nil, payloadError(err)
+	}
+	return chunks, nil
+}
+
+// writeValueError replies to a readValue error. Deadline errors are returned
+// as is so the caller drops the connection.
+func writeValueError(w *ResponseWriter, err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	if errors.Is(err, errItemTooLarge) {
+		return writeServerError(w, err.Error())
+	}
+	return writeClientError(w, err.Error())
+}
+
+func remoteAddrString(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil || addr.String() == "" {
+		return "local"
+	}
+	return addr.String()
+}
+
+// maxRetainedBuf is the largest get buffer a connection keeps between
+// commands, so a single large reply does not pin its memory for the rest of
+// the connection's life.
+const maxRetainedBuf = 1 << 20
+
+// ResponseWriter buffers replies and records the outcome of the current
+// command for metrics. Replies are flushed after each command.
+type ResponseWriter struct {
+	*bufio.Writer
+	result string
+
+	// hits, valueBuf and inflateBuf are reused by get; valueBuf holds values
+	// copied out of storage that cannot be referenced after the cache lock is
+	// released, and inflateBuf the value being decompressed.
+	hits       []cache.Hit
+	valueBuf   []byte
+	inflateBuf []byte
+}
+
+// release drops the references the last get left in hits and the buffers
+// that grew past maxRetainedBuf.
+func (w *ResponseWriter) release() {
+	clear(w.hits)
+	if cap(w.valueBuf) > maxRetainedBuf {
+		w.valueBuf = nil
+	}
+	if cap(w.inflateBuf) > maxRetainedBuf {
+		w.inflateBuf = nil
+	}
+}
+
+// SetResult records the outcome of the command for metrics: one of "ok",
+// "stored", "deleted", "not_found", "client_error", "server_error" and
+// "denied". Other values are not counted.
+func (w *ResponseWriter) SetResult(result string) {
+	w.result = result
+}
+
+// ClientError replies CLIENT_ERROR with msg.
+func (w *ResponseWriter) ClientError(msg string) error {
+	return writeClientError(w, msg)
+}
+
+// ServerError replies SERVER_ERROR with msg.
+func (w *ResponseWriter) ServerError(msg string) error {
+	return writeServerError(w, msg)
+}
+
+func writeClientError(w *ResponseWriter, msg string) error {
+	w.result = resultClientError
+	_, err := fmt.Fprintf(w, "CLIENT_ERROR %s\r\n", msg)
+	return err
+}
+
+// writeDenied replies like writeClientError but records the command as
+// denied by authentication or ACLs.
+func writeDenied(w *ResponseWriter, msg string) error {
+	err := writeClientError(w, msg)
+	w.result = resultDenied
+	return err
+}
+
+func writeServerError(w *ResponseWriter, msg string) error {
+	w.result = resultServerError
+	_, err := fmt.Fprintf(w, "SERVER_ERROR %s\r\n", msg)
+	return err
+}
+
+// readCommandLine accepts CRLF, LF, CR and CR NUL (common telnet newline).
+// Lines longer than maxLen bytes return errLineTooLong.
+func readCommandLine(r *bufio.Reader, maxLen int) (string, error) {
+	var buf bytes.Buffer
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && buf.Len() > 0 {
+				return buf.String(), nil
+			}
+			return "", err
+		}
+
+		switch b {
+		case '\n':
+			return buf.String(), nil
+		case '\r':
+			next, err := r.ReadByte()
+			if err == nil {
+				if next != '\n' && next != 0x00 {
+					if unreadErr := r.UnreadByte(); unreadErr != nil {
+						return "", unreadErr
+					}
+				}
+			} else if !errors.Is(err, io.EOF) {
+				return "", err
+			}
+			return buf.String(), nil
+		default:
+			if buf.Len() >= maxLen {
+				return "", errLineTooLong
+			}
+			buf.WriteByte(b)
+		}
+	}
+}
+
+// consumeChunkTerminator accepts CRLF, LF, CR and CR NUL after set payload.
+func consumeChunkTerminator(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case '\n':
+		return nil
+	case '\r':
+		next, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if next == '\n' || next == 0x00 {
+			return nil
+		}
+		return fmt.Errorf("invalid chunk terminator")
+	default:
+		return fmt.Errorf("invalid chunk terminator")
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// rejectTimeout bounds the reply written to a rejected connection, including
+// the TLS handshake that writing it starts on a TLS listener.
+const rejectTimeout = time.Second
+
+// Stats is a snapshot of server counters.
+type Stats struct {
+	CurrConnections     int64
+	TotalConnections    int64
+	RejectedConnections int64
+	MaxConnections      int64
+	IdleTimeouts        int64
+	ReadTimeouts        int64
+	WriteTimeouts       int64
+}
+
+func (s *Server) Stats() Stats {
+	return Stats{
+		CurrConnections:     s.currConns.Load(),
+		TotalConnections:    s.totalConns.Load(),
+		RejectedConnections: s.rejectedConns.Load(),
+		MaxConnections:      s.maxConns.Load(),
+		IdleTimeouts:        s.idleTimeouts.Load(),
+		ReadTimeouts:        s.readTimeouts.Load(),
+		WriteTimeouts:       s.writeTimeouts.Load(),
+	}
+}
+
+// MaxConns returns the connection limit; 0 means unlimited.
+func (s *Server) MaxConns() int {
+	return int(s.maxConns.Load())
+}
+
+// SetMaxConns changes the connection limit. Connections already open are not
+// closed when the limit is lowered.
+func (s *Server) SetMaxConns(n int) {
+	if n < 0 {
+		n = 0
+	}
+	s.maxConns.Store(int64(n))
+}
+
+// admitConn reserves a connection slot, or replies like memcached and closes
+// conn when the limit is reached. The reply is written from another goroutine
+// so a peer that is slow to handshake or read cannot stall the accept loop.
+func (s *Server) admitConn(conn net.Conn) bool {
+	curr := s.currConns.Add(1)
+	if limit := s.maxConns.Load(); limit > 0 && curr > limit {
+		s.currConns.Add(-1)
+		s.rejectedConns.Add(1)
+		s.logf("rejecting connection from %s: too many open connections", remoteAddrString(conn))
+
+		go func() {
+			_ = conn.SetDeadline(time.Now().Add(rejectTimeout))
+			_, _ = conn.Write([]byte("SERVER_ERROR too many open connections\r\n"))
+			_ = conn.Close()
+		}()
+		return false
+	}
+	s.totalConns.Add(1)
+	return true
+}
+
+func (s *Server) releaseConn() {
+	s.currConns.Add(-1)
+}
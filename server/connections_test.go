@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func dialAndRead(t *testing.T, addr, cmd string) (net.Conn, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if cmd != "" {
+		if _, err := conn.Write([]byte(cmd)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return conn, line
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	srv, stop := startServer(t, Config{ListenAddr: "127.0.0.1:0", MaxConns: 1})
+	defer stop()
+
+	first, resp := dialAndRead(t, srv.Addr(), "version\r\n")
+	if resp != "VERSION (devel)\r\n" {
+		t.Fatalf("unexpected version response: %q", resp)
+	}
+
+	rejected, resp := dialAndRead(t, srv.Addr(), "")
+	rejected.Close()
+	if resp != "SERVER_ERROR too many open connections\r\n" {
+		t.Fatalf("unexpected rejection response: %q", resp)
+	}
+
+	st := srv.Stats()
+	if st.CurrConnections != 1 || st.TotalConnections != 1 || st.RejectedConnections != 1 || st.MaxConnections != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	srv.SetMaxConns(2)
+	second, resp := dialAndRead(t, srv.Addr(), "version\r\n")
+	second.Close()
+	if resp != "VERSION (devel)\r\n" {
+		t.Fatalf("unexpected response after raising limit: %q", resp)
+	}
+
+	first.Close()
+	waitFor(t, func() bool { return srv.Stats().CurrConnections == 0 })
+	if got := srv.Stats().TotalConnections; got != 2 {
+		t.Fatalf("TotalConnections = %d, want 2", got)
+	}
+}
+
+func TestMaxConnsTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server-key.pem")
+	certPEM, keyPEM := ca.issue(t, 10, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+
+	srv, stop := startServer(t, Config{
+		ListenAddr:  "127.0.0.1:0",
+		MaxConns:    1,
+		TLSCertFile: certFile,
+		TLSKeyFile:  keyFile,
+	})
+	defer stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCfg := &tls.Config{RootCAs: roots}
+
+	first, err := tls.Dial("tcp", srv.Addr(), clientCfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer first.Close()
+	waitFor(t, func() bool { return srv.Stats().CurrConnections == 1 })
+
+	// A peer that never sends a ClientHello must not hold up the accept loop.
+	stalled, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stalled.Close()
+	waitFor(t, func() bool { return srv.Stats().RejectedConnections == 1 })
+
+	resp, _, err := tlsRoundTrip(clientCfg, srv.Addr(), "")
+	if err != nil {
+		t.Fatalf("rejected round trip failed: %v", err)
+	}
+	if resp != "SERVER_ERROR too many open connections\r\n" {
+		t.Fatalf("unexpected rejection response: %q", resp)
+	}
+	if got := srv.Stats().RejectedConnections; got != 2 {
+		t.Fatalf("RejectedConnections = %d, want 2", got)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"time"
+)
+
+// Request is one command read from a client connection.
+type Request struct {
+	// Command is the lower-cased command name and Args the words after it.
+	// Middleware may rewrite both before passing the request on.
+	Command string
+	Args    []string
+	// Conn describes the connection; Conn.User is set once it authenticates.
+	Conn *ConnInfo
+	// Start is when the command line was read.
+	Start time.Time
+	// Reader is positioned after the command line. Commands followed by a
+	// data block, like set, read it from here.
+	Reader *bufio.Reader
+
+	server *Server
+}
+
+// Keys returns the keys the command operates on, as declared by its
+// Command. For the built-in commands it is a subslice of Args, so assigning
+// to its elements rewrites the keys.
+func (r *Request) Keys() []string {
+	cmd := r.server.command(r.Command)
+	if cmd == nil || cmd.Keys == nil {
+		return nil
+	}
+	return cmd.Keys(r.Args)
+}
+
+// Reject skips the command's data block, if any, and replies CLIENT_ERROR
+// with msg. Middleware refusing a command should use it so the connection
+// stays in sync.
+func (r *Request) Reject(w *ResponseWriter, msg string) error {
+	if cmd := r.server.command(r.Command); cmd != nil && cmd.PayloadSize != nil {
+		if n := cmd.PayloadSize(r.Args); n >= 0 {
+			if err := discardPayload(r.Reader, n); err != nil {
+				return writeValueError(w, payloadError(err))
+			}
+		}
+	}
+	return writeDenied(w, msg)
+}
+
+// Handler runs a command and writes its reply. Returning an error closes the
+// connection, so protocol errors the client can recover from should be
+// replied to instead.
+type Handler interface {
+	ServeCommand(w *ResponseWriter, req *Request) error
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(w *ResponseWriter, req *Request) error
+
+func (f HandlerFunc) ServeCommand(w *ResponseWriter, req *Request) error {
+	return f(w, req)
+}
+
+// Middleware wraps a Handler, for example to trace, rate limit or rewrite
+// commands.
+type Middleware func(next Handler) Handler
+
+// Command is a command registered with Server.Handle.
+type Command struct {
+	Handler Handler
+	// Keys returns the keys in args; nil means the command takes none.
+	Keys func(args []string) []string
+	// PayloadSize returns the length of the data block that follows the
+	// command line, or -1 when there is none or args are invalid. Nil means
+	// the command never has one.
+	PayloadSize func(args []string) int
+}
+
+// Handle registers cmd under name, replacing a built-in command of the same
+// name. ACL commands= lists may name the command, and their prefixes= rules
+// apply to the keys cmd.Keys returns; a principal with a commands= list that
+// neither names it nor includes "all" may not run it. Commands other than the
+// built-in ones are counted as "unknown" in metrics. Handle must not be called
+// once the server is serving.
+func (s *Server) Handle(name string, cmd Command) {
+	s.commands[name] = &cmd
+}
+
+// Use appends middleware to the chain every command passes through, in the
+// order given. Middleware runs before authentication and ACL checks, so it
+// sees every command, and ACLs apply to the keys it rewrote. Use must not be
+// called once the server is serving.
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+func (s *Server) command(name string) *Command {
+	return s.commands[name]
+}
+
+// rootHandler returns the middleware chain around the command router,
+// building it on first use.
+func (s *Server) rootHandler() Handler {
+	s.handlerOnce.Do(func() {
+		h := s.requireAuth(s.checkACL(HandlerFunc(s.route)))
+		for i := len(s.middleware) - 1; i >= 0; i-- {
+			h = s.middleware[i](h)
+		}
+		s.handler = h
+	})
+	return s.handler
+}
+
+func (s *Server) route(w *ResponseWriter, req *Request) error {
+	cmd := s.command(req.Command)
+	if cmd == nil {
+		return writeClientError(w, "unknown command")
+	}
+	return cmd.Handler.ServeCommand(w, req)
+}
+
+func (s *Server) builtinCommands() map[string]*Command {
+	allArgs := func(args []string) []string { return args }
+	firstArg := func(args []string) []string {
+		if len(args) > 0 {
+			return args[:1]
+		}
+		return nil
+	}
+	setPayloadSize := func(args []string) int {
+		if _, _, n, err := parseSetArgs(args); err == nil {
+			return n
+		}
+		return -1
+	}
+
+	return map[string]*Command{
+		"get": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleGetLike(w, req.Args, false)
+			}),
+			Keys: allArgs,
+		},
+		"gets": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleGetLike(w, req.Args, true)
+			}),
+			Keys: allArgs,
+		},
+		"set": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleSet(req.Reader, w, req.Args)
+			}),
+			Keys:        firstArg,
+			PayloadSize: setPayloadSize,
+		},
+		"delete": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleDelete(w, req.Args)
+			}),
+			Keys: firstArg,
+		},
+		"incr": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleIncrDecr(w, req.Args, true)
+			}),
+			Keys: firstArg,
+		},
+		"decr": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleIncrDecr(w, req.Args, false)
+			}),
+			Keys: firstArg,
+		},
+		"version": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleVersion(w)
+			}),
+		},
+		"cache_memlimit": {
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				return s.handleCacheMemlimit(w, req.Args)
+			}),
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareAndCustomCommand(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 20})
+
+	var seen []string
+	srv.Use(func(next Handler) Handler {
+		return HandlerFunc(func(w *ResponseWriter, req *Request) error {
+			seen = append(seen, req.Command+" "+strings.Join(req.Keys(), ","))
+			if req.Start.IsZero() || req.Conn.RemoteAddr == "" {
+				t.Errorf("missing request info: %+v", req)
+			}
+			return next.ServeCommand(w, req)
+		})
+	})
+	// Namespace every key.
+	srv.Use(func(next Handler) Handler {
+		return HandlerFunc(func(w *ResponseWriter, req *Request) error {
+			for i, key := range req.Keys() {
+				req.Keys()[i] = "tenant:" + key
+			}
+			return next.ServeCommand(w, req)
+		})
+	})
+	srv.Use(func(next Handler) Handler {
+		return HandlerFunc(func(w *ResponseWriter, req *Request) error {
+			if keys := req.Keys(); len(keys) > 0 && keys[0] == "tenant:blocked" {
+				return req.Reject(w, "rate limited")
+			}
+			return next.ServeCommand(w, req)
+		})
+	})
+	srv.Handle("ping", Command{
+		Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+			w.SetResult("ok")
+			_, err := w.WriteString("PONG " + strings.Join(req.Args, " ") + "\r\n")
+			return err
+		}),
+	})
+
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+
+	if resp := sendCommand(t, conn, "set a 0 0 1\r\nx\r\n", "\r\n"); resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	if _, ok := srv.Cache().Get("tenant:a"); !ok {
+		t.Fatal("key was not rewritten")
+	}
+	if resp := sendCommand(t, conn, "get a\r\n", "END\r\n"); resp != "VALUE tenant:a 0 1\r\nx\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+	// The rejected payload is skipped, so the next command is read correctly.
+	if resp := sendCommand(t, conn, "set blocked 0 0 3\r\nabc\r\n", "\r\n"); resp != "CLIENT_ERROR rate limited\r\n" {
+		t.Fatalf("unexpected rejected set response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "ping 1 2\r\n", "\r\n"); resp != "PONG 1 2\r\n" {
+		t.Fatalf("unexpected ping response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "nope\r\n", "\r\n"); resp != "CLIENT_ERROR unknown command\r\n" {
+		t.Fatalf("unexpected unknown command response: %q", resp)
+	}
+
+	want := []string{"set a", "get a", "set blocked", "ping ", "nope "}
+	if strings.Join(seen, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected requests seen: %q", seen)
+	}
+}
+
+func TestACLAppliesToRewrittenKeys(t *testing.T) {
+	acl, err := parseACL(strings.NewReader("* prefixes=tenant:\n"))
+	if err != nil {
+		t.Fatalf("parse acl: %v", err)
+	}
+	srv := NewServer(Config{MaxBytes: 1 << 20, ACL: acl})
+	srv.Use(func(next Handler) Handler {
+		return HandlerFunc(func(w *ResponseWriter, req *Request) error {
+			if req.Command == "get" {
+				for i, key := range req.Keys() {
+					req.Keys()[i] = "tenant:" + key
+				}
+			}
+			return next.ServeCommand(w, req)
+		})
+	})
+
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+
+	if resp := sendCommand(t, conn, "get a\r\n", "END\r\n"); resp != "END\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "set a 0 0 1\r\nx\r\n", "\r\n"); resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+}
+
+func TestACLCoversCustomCommands(t *testing.T) {
+	newServer := func(rules string) *Server {
+		t.Helper()
+		acl, err := parseACL(strings.NewReader(rules))
+		if err != nil {
+			t.Fatalf("parse acl: %v", err)
+		}
+		srv := NewServer(Config{MaxBytes: 1 << 20, ACL: acl})
+		srv.Handle("touch", Command{
+			Handler: HandlerFunc(func(w *ResponseWriter, req *Request) error {
+				_, err := w.WriteString("TOUCHED\r\n")
+				return err
+			}),
+			Keys: func(args []string) []string { return args[:min(len(args), 1)] },
+		})
+		return srv
+	}
+
+	// A commands= list that does not name the command denies it.
+	conn, stop := newPipeSessionServer(t, newServer("* commands=read\n"))
+	defer stop()
+	if resp := sendCommand(t, conn, "touch a\r\n", "\r\n"); resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected unlisted touch response: %q", resp)
+	}
+
+	// Named commands are allowed, with prefixes applied to their keys.
+	conn, stop = newPipeSessionServer(t, newServer("* commands=read,TOUCH prefixes=a:\n"))
+	defer stop()
+	if resp := sendCommand(t, conn, "touch a:k\r\n", "\r\n"); resp != "TOUCHED\r\n" {
+		t.Fatalf("unexpected allowed touch response: %q", resp)
+	}
+	if resp := sendCommand(t, conn, "touch b:k\r\n", "\r\n"); resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected denied touch response: %q", resp)
+	}
+
+	// "all" includes custom commands, and a removed one stays denied.
+	conn, stop = newPipeSessionServer(t, newServer("* commands=all\n"))
+	defer stop()
+	if resp := sendCommand(t, conn, "touch a\r\n", "\r\n"); resp != "TOUCHED\r\n" {
+		t.Fatalf("unexpected touch response with all: %q", resp)
+	}
+	conn, stop = newPipeSessionServer(t, newServer("* commands=all,-touch\n"))
+	defer stop()
+	if resp := sendCommand(t, conn, "touch a\r\n", "\r\n"); resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected removed touch response: %q", resp)
+	}
+
+	// Names the server does not know are rejected when the ACL is used.
+	srv := newServer("* commands=read,bogus\n")
+	if err := srv.Serve(t.Context(), newPipeListener()); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Fatalf("expected unknown command error, got: %v", err)
+	}
+	if err := srv.Reload(RuntimeConfig{ACL: srv.acl()}); err == nil {
+		t.Fatal("expected reload to reject unknown command")
+	}
+}
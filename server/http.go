@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpReadHeaderTimeout bounds slow HTTP clients on the side endpoints.
+const httpReadHeaderTimeout = 10 * time.Second
+
+// httpEndpoint is an optional HTTP side port such as /metrics.
+type httpEndpoint struct {
+	name string
+	ln   net.Listener
+	srv  *http.Server
+}
+
+// listenHTTP opens the configured HTTP endpoints. They are served once Serve
+// is ready and closed by Close.
+func (s *Server) listenHTTP() ([]httpEndpoint, error) {
+	var eps []httpEndpoint
+	add := func(name, addr string, h http.Handler) error {
+		if addr == "" {
+			return nil
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		eps = append(eps, httpEndpoint{
+			name: name,
+			ln:   ln,
+			srv:  &http.Server{Handler: h, ReadHeaderTimeout: httpReadHeaderTimeout},
+		})
+		return nil
+	}
+
+	if s.cfg.AdminListenAddr != "" && s.cfg.AdminToken == "" {
+		return nil, fmt.Errorf("admin endpoint requires a token")
+	}
+
+	err := add("metrics", s.cfg.MetricsListenAddr, s.metricsMux())
+	if err == nil {
+		err = add("admin", s.cfg.AdminListenAddr, s.adminMux())
+	}
+	if err != nil {
+		for _, ep := range eps {
+			_ = ep.ln.Close()
+		}
+		return nil, err
+	}
+	return eps, nil
+}
+
+func (s *Server) metricsMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.MetricsHandler())
+	return mux
+}
+
+func (s *Server) serveHTTP(ep httpEndpoint) {
+	s.logf("%s listening on %s", ep.name, ep.ln.Addr().String())
+	if err := ep.srv.Serve(ep.ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logf("%s serve error: %v", ep.name, err)
+	}
+}
+
+func (s *Server) httpAddr(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, ep := range s.httpEndpoints {
+		if ep.name == name {
+			return ep.ln.Addr().String()
+		}
+	}
+	return ""
+}
+
+// MetricsAddr returns the address of the metrics endpoint, or "" when it is
+// disabled or not yet listening.
+func (s *Server) MetricsAddr() string {
+	return s.httpAddr("metrics")
+}
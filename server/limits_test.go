@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamConn is a net.Conn that reads a fixed input and records the output.
+type streamConn struct {
+	io.Reader
+	out bytes.Buffer
+}
+
+func (c *streamConn) Write(p []byte) (int, error)      { return c.out.Write(p) }
+func (c *streamConn) Close() error                     { return nil }
+func (c *streamConn) LocalAddr() net.Addr              { return nil }
+func (c *streamConn) RemoteAddr() net.Addr             { return nil }
+func (c *streamConn) SetDeadline(time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(time.Time) error { return nil }
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func limitsConfig() Config {
+	return Config{
+		MaxBytes:      1 << 20,
+		MaxLineLength: 128,
+		MaxKeyLength:  8,
+		MaxItemSize:   16,
+	}
+}
+
+func TestKeyAndItemLimits(t *testing.T) {
+	conn, stop := newPipeSessionConfig(t, limitsConfig())
+	defer stop()
+
+	resp := sendCommand(t, conn, "set 123456789 0 0 1\r\nv\r\n", "\r\n")
+	if resp != "CLIENT_ERROR key too long\r\n" {
+		t.Fatalf("unexpected long key set response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "get 123456789\r\n", "\r\n")
+	if resp != "CLIENT_ERROR key too long\r\n" {
+		t.Fatalf("unexpected long key get response: %q", resp)
+	}
+
+	resp = sendCommand(t, conn, "set k 0 0 17\r\n"+strings.Repeat("x", 17)+"\r\n", "\r\n")
+	if resp != "SERVER_ERROR object too large for cache\r\n" {
+		t.Fatalf("unexpected large item response: %q", resp)
+	}
+
+	// The swallowed payloads must leave the connection in sync.
+	resp = sendCommand(t, conn, "set k 0 0 2\r\nok\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "get k\r\n", "END\r\n")
+	if resp != "VALUE k 0 2\r\nok\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+}
+
+func TestLineTooLongClosesConnection(t *testing.T) {
+	conn := &streamConn{Reader: strings.NewReader("get " + strings.Repeat("k", 200) + "\r\nget k\r\n")}
+	NewServer(limitsConfig()).handleConn(conn)
+
+	if got := conn.out.String(); got != "CLIENT_ERROR line too long\r\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
+
+func TestOversizedValueIsNotBuffered(t *testing.T) {
+	const payload = 64 << 20
+	conn := &streamConn{Reader: io.MultiReader(
+		strings.NewReader("set k 0 0 67108864\r\n"),
+		io.LimitReader(zeroReader{}, payload),
+		strings.NewReader("\r\nget k\r\n"),
+	)}
+	srv := NewServer(Config{MaxBytes: 1 << 30, MaxItemSize: 1 << 20})
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	srv.handleConn(conn)
+	runtime.ReadMemStats(&after)
+
+	if got := conn.out.String(); got != "SERVER_ERROR object too large for cache\r\nEND\r\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > payload/8 {
+		t.Fatalf("allocated %d bytes for a swallowed %d byte payload", alloc, payload)
+	}
+}
+
+func FuzzReadCommandLine(f *testing.F) {
+	f.Add([]byte("get a b c\r\n"), 16)
+	f.Add([]byte("set k 0 0 1\rv"), 4)
+	f.Add(bytes.Repeat([]byte("x"), 1024), 64)
+	f.Fuzz(func(t *testing.T, data []byte, maxLen int) {
+		if maxLen <= 0 || maxLen > 4096 {
+			return
+		}
+		line, err := readCommandLine(newReader(data), maxLen)
+		if err == nil && len(line) > maxLen {
+			t.Fatalf("line of %d bytes exceeds limit %d", len(line), maxLen)
+		}
+	})
+}
+
+func FuzzHandleConn(f *testing.F) {
+	f.Add([]byte("set k 0 0 1\r\nv\r\nget k\r\n"))
+	f.Add([]byte("set k 0 0 2147483647\r\n"))
+	f.Add([]byte("set k 0 0 20\r\nshort\r\nget k\r\n"))
+	f.Add([]byte("incr k 1\r\ndecr k 5\r\ndelete k\r\ngets k k k\r\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conn := &streamConn{Reader: bytes.NewReader(data)}
+		cfg := limitsConfig()
+		cfg.MaxItemSize = 1024
+		srv := NewServer(cfg)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		srv.handleConn(conn)
+		runtime.ReadMemStats(&after)
+
+		// Work is bounded by the input, never by sizes claimed in it.
+		limit := uint64(1<<20 + 64*len(data))
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > limit {
+			t.Fatalf("allocated %d bytes for %d bytes of input", alloc, len(data))
+		}
+	})
+}
+
+func newReader(data []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(data))
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const unixAddrPrefix = "unix:"
+
+// DefaultUnixSocketPerm matches memcached's default for -a.
+const DefaultUnixSocketPerm os.FileMode = 0o700
+
+var ErrSocketInUse = errors.New("unix socket is already in use")
+
+// listenAddrs returns ListenAddr followed by ListenAddrs with empty entries dropped.
+func (c Config) listenAddrs() []string {
+	addrs := make([]string, 0, 1+len(c.ListenAddrs))
+	if c.ListenAddr != "" {
+		addrs = append(addrs, c.ListenAddr)
+	}
+	for _, addr := range c.ListenAddrs {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// SplitListenAddrs splits a comma-separated -listen value.
+func SplitListenAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (s *Server) listenAll() ([]net.Listener, error) {
+	addrs := s.cfg.listenAddrs()
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no listen address")
+	}
+
+	var tlsCfg *tls.Config
+	if s.tlsEnabled() {
+		var err error
+		tlsCfg, err = s.newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := s.listen(addr, tlsCfg)
+		if err != nil {
+			for _, opened := range lns {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// listen opens addr. TLS is applied to TCP listeners only; Unix sockets are
+// local and rely on file permissions instead.
+func (s *Server) listen(addr string, tlsCfg *tls.Config) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil || tlsCfg == nil {
+			return ln, err
+		}
+		return tls.NewListener(ln, tlsCfg), nil
+	}
+	if path == "" {
+		return nil, fmt.Errorf("empty unix socket path")
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	perm := s.cfg.UnixSocketPerm
+	if perm == 0 {
+		perm = DefaultUnixSocketPerm
+	}
+	ln, err := listenUnix(path, perm)
+	if err != nil {
+		return nil, err
+	}
+	// The socket file is unlinked by the listener on Close.
+	if err := os.Chmod(path, perm); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a previous process.
+// A socket that still accepts connections is reported as ErrSocketInUse.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s: %w", path, ErrSocketInUse)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package server
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix creates a unix socket at path. There is no umask here, so the
+// mode is only set by the Chmod that follows.
+func listenUnix(path string, _ os.FileMode) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
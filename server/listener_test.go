@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, cfg Config) (*Server, func()) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := NewServer(cfg)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe(ctx)
+	}()
+
+	select {
+	case <-srv.Ready():
+	case err := <-errCh:
+		t.Fatalf("server failed before ready: %v", err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not become ready")
+	}
+
+	return srv, func() {
+		cancel()
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatalf("serve returned error: %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("server shutdown timeout")
+		}
+	}
+}
+
+func roundTrip(t *testing.T, network, addr, cmd, readUntil string) string {
+	t.Helper()
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("dial %s %s: %v", network, addr, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	var b strings.Builder
+	for !strings.HasSuffix(b.String(), readUntil) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+func TestServeTCPAndUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "utsuro.sock")
+	srv, stop := startServer(t, Config{
+		ListenAddrs:    []string{"127.0.0.1:0", "unix:" + sock},
+		UnixSocketPerm: 0o600,
+		MaxBytes:       1 << 20,
+	})
+
+	addrs := srv.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("unexpected addrs: %v", addrs)
+	}
+	if srv.Addr() != addrs[0] {
+		t.Fatalf("Addr() = %q, want %q", srv.Addr(), addrs[0])
+	}
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("socket perm = %o, want 600", perm)
+	}
+
+	resp := roundTrip(t, "unix", sock, "set k 0 0 1\r\nv\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	resp = roundTrip(t, "tcp", addrs[0], "get k\r\n", "END\r\n")
+	if resp != "VALUE k 0 1\r\nv\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+
+	stop()
+	if _, err := os.Lstat(sock); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("socket should be removed on shutdown: %v", err)
+	}
+}
+
+func TestServeRemovesStaleUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "utsuro.sock")
+
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// Simulate a crashed process that left its socket file behind.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ln.Close()
+
+	_, stop := startServer(t, Config{ListenAddr: "unix:" + sock})
+	defer stop()
+
+	resp := roundTrip(t, "unix", sock, "get missing\r\n", "END\r\n")
+	if resp != "END\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+}
+
+func TestServeRejectsSocketInUse(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "utsuro.sock")
+	_, stop := startServer(t, Config{ListenAddr: "unix:" + sock})
+	defer stop()
+
+	err := NewServer(Config{ListenAddr: "unix:" + sock}).ListenAndServe(context.Background())
+	if !errors.Is(err, ErrSocketInUse) {
+		t.Fatalf("expected ErrSocketInUse, got: %v", err)
+	}
+}
+
+// pipeListener is an in-memory listener whose connections come from dial.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestServeInMemoryListener(t *testing.T) {
+	ln := newPipeListener()
+	srv := NewServer(Config{MaxBytes: 1 << 20})
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ctx, ln)
+	}()
+	<-srv.Ready()
+	if srv.Addr() != "pipe" {
+		t.Fatalf("unexpected addr: %q", srv.Addr())
+	}
+
+	conn, err := ln.dial()
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	go func() {
+		_, _ = conn.Write([]byte("set k 0 0 1\r\nv\r\nget k\r\n"))
+	}()
+	r := bufio.NewReader(conn)
+	var b strings.Builder
+	for !strings.HasSuffix(b.String(), "END\r\n") {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		b.WriteString(line)
+	}
+	if got := b.String(); got != "STORED\r\nVALUE k 0 1\r\nv\r\nEND\r\n" {
+		t.Fatalf("unexpected reply: %q", got)
+	}
+	if st := srv.Stats(); st.CurrConnections != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if _, ok := srv.Cache().Get("k"); !ok {
+		t.Fatal("value missing from the cache")
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("serve returned error: %v", err)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestSplitListenAddrs(t *testing.T) {
+	got := SplitListenAddrs(" 127.0.0.1:11211, unix:/tmp/a.sock,,")
+	want := []string{"127.0.0.1:11211", "unix:/tmp/a.sock"}
+	if len(got) != len(want) {
+		t.Fatalf("SplitListenAddrs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("SplitListenAddrs = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the umask changes of listenUnix.
+var umaskMu sync.Mutex
+
+// listenUnix creates a unix socket at path whose mode is at most perm from
+// the start, so it is never reachable more widely before the Chmod that
+// follows. The umask is process-wide; files created by other goroutines
+// meanwhile get the stricter mask too.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(int(0o777 &^ perm.Perm()))
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
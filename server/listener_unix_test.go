@@ -0,0 +1,34 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenUnixCreatesWithPerm(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "u.sock")
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	ln, err := listenUnix(sock, 0o600)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	// No Chmod has run yet; the mode comes from the umask alone.
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("socket created with mode %o, want 600", perm)
+	}
+	if got := syscall.Umask(0); got != 0 {
+		t.Fatalf("umask left at %o", got)
+	}
+}
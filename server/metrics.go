@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	resultOK          = "ok"
+	resultStored      = "stored"
+	resultDeleted     = "deleted"
+	resultNotFound    = "not_found"
+	resultClientError = "client_error"
+	resultServerError = "server_error"
+	resultDenied      = "denied"
+)
+
+// metricCommands and metricResults are the label values exported for
+// commands. Anything else is folded into "unknown" to bound cardinality.
+var (
+	metricCommands = []string{"get", "gets", "set", "delete", "incr", "decr", "version", "cache_memlimit", "unknown"}
+	metricResults  = []string{resultOK, resultStored, resultDeleted, resultNotFound, resultClientError, resultServerError, resultDenied}
+)
+
+// latencyBuckets are the histogram upper bounds in seconds.
+var latencyBuckets = []float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005,
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+var (
+	metricCommandIndex = indexOf(metricCommands)
+	metricResultIndex  = indexOf(metricResults)
+)
+
+func indexOf(names []string) map[string]int {
+	m := make(map[string]int, len(names))
+	for i, name := range names {
+		m[name] = i
+	}
+	return m
+}
+
+type histogram struct {
+	// buckets holds non-cumulative counts; the last one is +Inf.
+	buckets []atomic.Uint64
+	sumNs   atomic.Int64
+	count   atomic.Uint64
+}
+
+// metrics holds per-command counters and latency histograms.
+type metrics struct {
+	commands  [][]atomic.Uint64
+	latencies []histogram
+
+	getHits   atomic.Uint64
+	getMisses atomic.Uint64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		commands:  make([][]atomic.Uint64, len(metricCommands)),
+		latencies: make([]histogram, len(metricCommands)),
+	}
+	for i := range m.commands {
+		m.commands[i] = make([]atomic.Uint64, len(metricResults))
+		m.latencies[i].buckets = make([]atomic.Uint64, len(latencyBuckets)+1)
+	}
+	return m
+}
+
+func (m *metrics) observe(cmd, result string, d time.Duration) {
+	ci, ok := metricCommandIndex[cmd]
+	if !ok {
+		ci = metricCommandIndex["unknown"]
+	}
+	if ri, ok := metricResultIndex[result]; ok {
+		m.commands[ci][ri].Add(1)
+	}
+
+	h := &m.latencies[ci]
+	sec := d.Seconds()
+	bi := len(latencyBuckets)
+	for i, le := range latencyBuckets {
+		if sec <= le {
+			bi = i
+			break
+		}
+	}
+	h.buckets[bi].Add(1)
+	h.sumNs.Add(int64(d))
+	h.count.Add(1)
+}
+
+// MetricsHandler serves metrics in the Prometheus text exposition format.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = s.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes metrics in the Prometheus text exposition format.
+func (s *Server) WriteMetrics(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	m := s.metrics
+
+	writeHeader(w, "utsuro_commands_total", "counter", "Commands processed by command and result.")
+	for ci, cmd := range metricCommands {
+		for ri, result := range metricResults {
+			if v := m.commands[ci][ri].Load(); v > 0 {
+				fmt.Fprintf(w, "utsuro_commands_total{command=%q,result=%q} %d\n", cmd, result, v)
+			}
+		}
+	}
+
+	writeHeader(w, "utsuro_command_duration_seconds", "histogram", "Command latency measured from parsed line to reply buffered.")
+	for ci, cmd := range metricCommands {
+		h := &m.latencies[ci]
+		count := h.count.Load()
+		if count == 0 {
+			continue
+		}
+		var cum uint64
+		for bi, le := range latencyBuckets {
+			cum += h.buckets[bi].Load()
+			fmt.Fprintf(w, "utsuro_command_duration_seconds_bucket{command=%q,le=%q} %d\n", cmd, formatFloat(le), cum)
+		}
+		cum += h.buckets[len(latencyBuckets)].Load()
+		fmt.Fprintf(w, "utsuro_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", cmd, cum)
+		fmt.Fprintf(w, "utsuro_command_duration_seconds_sum{command=%q} %s\n", cmd, formatFloat(time.Duration(h.sumNs.Load()).Seconds()))
+		fmt.Fprintf(w, "utsuro_command_duration_seconds_count{command=%q} %d\n", cmd, cum)
+	}
+
+	hits, misses := m.getHits.Load(), m.getMisses.Load()
+	writeHeader(w, "utsuro_get_hits_total", "counter", "Keys found by get and gets.")
+	fmt.Fprintf(w, "utsuro_get_hits_total %d\n", hits)
+	writeHeader(w, "utsuro_get_misses_total", "counter", "Keys not found by get and gets.")
+	fmt.Fprintf(w, "utsuro_get_misses_total %d\n", misses)
+	writeHeader(w, "utsuro_get_hit_ratio", "gauge", "Ratio of hits to key lookups since start.")
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	fmt.Fprintf(w, "utsuro_get_hit_ratio %s\n", formatFloat(ratio))
+
+	cs := s.cache.Stats()
+	writeGauge(w, "utsuro_items", "Items currently stored.", cs.Items)
+	writeGauge(w, "utsuro_bytes", "Bytes charged against max bytes.", cs.UsedBytes)
+	writeGauge(w, "utsuro_logical_bytes", "Key and value bytes of stored items.", cs.LogicalBytes)
+	writeGauge(w, "utsuro_heap_bytes", "Estimated Go heap bytes held by stored items.", cs.HeapBytes)
+	writeGauge(w, "utsuro_max_bytes", "Configured max bytes.", cs.MaxBytes)
+	writeGauge(w, "utsuro_target_bytes", "Configured eviction target bytes.", cs.TargetBytes)
+	writeHeader(w, "utsuro_evictions_total", "counter", "Items removed to make room (capacity) or because they had expired (expired).")
+	fmt.Fprintf(w, "utsuro_evictions_total{reason=\"capacity\"} %d\n", cs.EvictedCapacity)
+	fmt.Fprintf(w, "utsuro_evictions_total{reason=\"expired\"} %d\n", cs.EvictedExpired)
+
+	cp := s.compressor
+	cin, cout := cp.inputBytes.Load(), cp.outputBytes.Load()
+	writeCounter(w, "utsuro_compression_input_bytes_total", "Bytes of values before compression.", cin)
+	writeCounter(w, "utsuro_compression_output_bytes_total", "Bytes of values after compression.", cout)
+	writeHeader(w, "utsuro_compression_ratio", "gauge", "Ratio of input to output bytes of compressed values since start.")
+	cratio := 0.0
+	if cout > 0 {
+		cratio = float64(cin) / float64(cout)
+	}
+	fmt.Fprintf(w, "utsuro_compression_ratio %s\n", formatFloat(cratio))
+	writeCounter(w, "utsuro_compression_skipped_total", "Values stored uncompressed because compression did not shrink them.", cp.storedRaw.Load())
+	writeCounter(w, "utsuro_decompressions_total", "Values decompressed for get.", cp.decompressions.Load())
+	writeHeader(w, "utsuro_compression_seconds_total", "counter", "CPU time spent compressing and decompressing values.")
+	fmt.Fprintf(w, "utsuro_compression_seconds_total{op=\"compress\"} %s\n", formatFloat(time.Duration(cp.compressNs.Load()).Seconds()))
+	fmt.Fprintf(w, "utsuro_compression_seconds_total{op=\"decompress\"} %s\n", formatFloat(time.Duration(cp.decompressNs.Load()).Seconds()))
+
+	st := s.Stats()
+	writeGauge(w, "utsuro_connections", "Open client connections.", st.CurrConnections)
+	writeGauge(w, "utsuro_max_connections", "Connection limit; 0 means unlimited.", st.MaxConnections)
+	writeCounter(w, "utsuro_connections_total", "Accepted client connections.", st.TotalConnections)
+	writeCounter(w, "utsuro_rejected_connections_total", "Connections rejected by the connection limit.", st.RejectedConnections)
+	writeHeader(w, "utsuro_timeouts_total", "counter", "Connections closed by a timeout, by kind.")
+	fmt.Fprintf(w, "utsuro_timeouts_total{kind=\"idle\"} %d\n", st.IdleTimeouts)
+	fmt.Fprintf(w, "utsuro_timeouts_total{kind=\"read\"} %d\n", st.ReadTimeouts)
+	fmt.Fprintf(w, "utsuro_timeouts_total{kind=\"write\"} %d\n", st.WriteTimeouts)
+
+	return w.Flush()
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeGauge(w *bufio.Writer, name, help string, v int64) {
+	writeHeader(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+func writeCounter(w *bufio.Writer, name, help string, v int64) {
+	writeHeader(w, name, "counter", help)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv, stop := startServer(t, Config{
+		ListenAddr:        "127.0.0.1:0",
+		MetricsListenAddr: "127.0.0.1:0",
+		MaxBytes:          1 << 20,
+	})
+	defer stop()
+
+	roundTrip(t, "tcp", srv.Addr(), "set k 0 0 1\r\nv\r\n", "\r\n")
+	roundTrip(t, "tcp", srv.Addr(), "get k missing\r\n", "END\r\n")
+	roundTrip(t, "tcp", srv.Addr(), "delete missing\r\n", "\r\n")
+	roundTrip(t, "tcp", srv.Addr(), "bogus\r\n", "\r\n")
+
+	resp, err := http.Get("http://" + srv.MetricsAddr() + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`utsuro_commands_total{command="set",result="stored"} 1`,
+		`utsuro_commands_total{command="get",result="ok"} 1`,
+		`utsuro_commands_total{command="delete",result="not_found"} 1`,
+		`utsuro_commands_total{command="unknown",result="client_error"} 1`,
+		`utsuro_command_duration_seconds_count{command="get"} 1`,
+		`utsuro_command_duration_seconds_bucket{command="set",le="+Inf"} 1`,
+		"utsuro_get_hits_total 1",
+		"utsuro_get_misses_total 1",
+		"utsuro_get_hit_ratio 0.5",
+		"utsuro_items 1",
+		"utsuro_max_bytes 1048576",
+		`utsuro_evictions_total{reason="capacity"} 0`,
+		"utsuro_connections_total 4",
+		"# TYPE utsuro_command_duration_seconds histogram",
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -64,3 +65,22 @@ func parseDeltaArgs(args []string) (key string, delta uint64, err error) {
 	}
 	return args[0], delta, nil
 }
+
+func parseMemlimitArgs(args []string) (maxBytes, targetBytes int64, err error) {
+	if len(args) != 1 && len(args) != 2 {
+		return 0, 0, fmt.Errorf("cache_memlimit requires megabytes")
+	}
+	mb := make([]int64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || v <= 0 || v > math.MaxInt64>>20 {
+			return 0, 0, fmt.Errorf("invalid megabytes")
+		}
+		mb[i] = v << 20
+	}
+	maxBytes = mb[0]
+	if len(mb) == 2 {
+		targetBytes = mb[1]
+	}
+	return maxBytes, targetBytes, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+// RuntimeConfig holds the settings that Reload can change on a running server.
+// Fields mean the same as in Config.
+type RuntimeConfig struct {
+	MaxBytes              int64
+	TargetBytes           int64
+	MaxEvictPerOp         int
+	IncrSlidingTTLSeconds int64
+	MaxConns              int
+	Verbose               bool
+	Credentials           Credentials
+	ACL                   *ACL
+}
+
+// Reload applies rc. Connections keep their authenticated user; new
+// credentials only affect later logins, while a new ACL applies to the next
+// command on every connection. New memory limits are applied in the
+// background, which evicts until usage fits or the server is closed; Stats of
+// the backend shows the progress. The memory settings are ignored unless the
+// backend is a TunableBackend. A MaxBytes of 0 or less means
+// cache.DefaultMaxBytes, as in Config. An ACL naming an unknown command is an
+// error; rc is checked in full before any setting changes, so nothing is
+// applied then.
+func (s *Server) Reload(rc RuntimeConfig) error {
+	if err := s.validateACL(rc.ACL); err != nil {
+		return err
+	}
+	if rc.MaxBytes <= 0 {
+		rc.MaxBytes = cache.DefaultMaxBytes
+	}
+	tb, tunable := s.cache.(TunableBackend)
+	if tunable {
+		tb.SetMaxEvictPerOp(rc.MaxEvictPerOp)
+		tb.SetIncrSlidingTTL(time.Duration(rc.IncrSlidingTTLSeconds) * time.Second)
+	}
+	s.SetMaxConns(rc.MaxConns)
+	s.verbose.Store(rc.Verbose)
+
+	s.mu.Lock()
+	s.creds = rc.Credentials
+	s.aclRules = rc.ACL
+	s.mu.Unlock()
+
+	if !tunable {
+		return nil
+	}
+	target := rc.TargetBytes
+	if target <= 0 || target > rc.MaxBytes {
+		target = rc.MaxBytes * 95 / 100
+	}
+	if cs := s.cache.Stats(); cs.MaxBytes == rc.MaxBytes && cs.TargetBytes == target {
+		return nil
+	}
+	go func() {
+		if err := s.Resize(rc.MaxBytes, target); err != nil {
+			s.logger.Warn("cache resize stopped", "error", err)
+		}
+	}()
+	return nil
+}
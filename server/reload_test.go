@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+func TestReload(t *testing.T) {
+	srv := NewServer(Config{
+		MaxBytes:    1 << 20,
+		Credentials: Credentials{"alice": "pw"},
+	})
+	serverSide, conn := net.Pipe()
+	go srv.handleConn(serverSide)
+	defer conn.Close()
+
+	resp := sendCommand(t, conn, "set auth 0 0 8\r\nalice pw\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected auth response: %q", resp)
+	}
+
+	acl, err := parseACL(strings.NewReader("alice commands=read\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	err = srv.Reload(RuntimeConfig{
+		MaxBytes:      2 << 20,
+		MaxEvictPerOp: 8,
+		MaxConns:      10,
+		Verbose:       true,
+		Credentials:   Credentials{"alice": "pw"},
+		ACL:           acl,
+	})
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	// The new limits apply in the background.
+	waitFor(t, func() bool {
+		st := srv.Cache().Stats()
+		return st.MaxBytes == 2<<20 && st.TargetBytes == (2<<20)*95/100
+	})
+	if got := srv.Stats().MaxConnections; got != 10 {
+		t.Fatalf("max connections = %d, want 10", got)
+	}
+	if !srv.verbose.Load() {
+		t.Fatal("verbose not enabled")
+	}
+
+	// The existing connection stays logged in and gets the new ACL.
+	resp = sendCommand(t, conn, "set k 0 0 1\r\nv\r\n", "\r\n")
+	if resp != "CLIENT_ERROR access denied\r\n" {
+		t.Fatalf("unexpected denied set response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "get k\r\n", "END\r\n")
+	if resp != "END\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+}
+
+func TestReloadAppliesNothingOnError(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 20, MaxConns: 5, Credentials: Credentials{"alice": "pw"}})
+
+	acl, err := parseACL(strings.NewReader("alice commands=nosuchcmd\n"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	err = srv.Reload(RuntimeConfig{
+		MaxBytes:    2 << 20,
+		MaxConns:    10,
+		Verbose:     true,
+		Credentials: Credentials{"bob": "pw"},
+		ACL:         acl,
+	})
+	if err == nil {
+		t.Fatal("reload with an unknown ACL command succeeded")
+	}
+	if got := srv.Stats().MaxConnections; got != 5 {
+		t.Fatalf("max connections = %d, want 5", got)
+	}
+	if srv.verbose.Load() {
+		t.Fatal("verbose enabled by a failed reload")
+	}
+	srv.mu.RLock()
+	_, alice := srv.creds["alice"]
+	srv.mu.RUnlock()
+	if !alice {
+		t.Fatal("credentials replaced by a failed reload")
+	}
+	if st := srv.Cache().Stats(); st.MaxBytes != 1<<20 {
+		t.Fatalf("max bytes = %d after a failed reload, want %d", st.MaxBytes, 1<<20)
+	}
+}
+
+func TestReloadDefaultMaxBytes(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 20})
+	if err := srv.Reload(RuntimeConfig{MaxBytes: 0, MaxConns: 10}); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	waitFor(t, func() bool { return srv.Cache().Stats().MaxBytes == cache.DefaultMaxBytes })
+	if got := srv.Stats().MaxConnections; got != 10 {
+		t.Fatalf("max connections = %d, want 10", got)
+	}
+}
+
+func TestResizeStopsOnClose(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 20, MaxEvictPerOp: 1})
+	for i := range 100 {
+		if err := srv.Cache().Set(fmt.Sprintf("k%d", i), 0, make([]byte, 1000)); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	srv.Close()
+
+	if err := srv.Resize(1000, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("resize after close = %v, want context.Canceled", err)
+	}
+	if st := srv.Cache().Stats(); st.Items < 90 {
+		t.Fatalf("shrink kept evicting after close: %d items left", st.Items)
+	}
+}
@@ -0,0 +1,396 @@
+// Package server serves a cache over the memcached text protocol. It is what
+// the utsuro command runs, and can be embedded in another program or test.
+package server
+
+import (
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/catatsuy/utsuro/cache"
+)
+
+const (
+	DefaultMaxLineLength = 64 * 1024
+	// DefaultMaxKeyLength is memcached's key length limit.
+	DefaultMaxKeyLength = 250
+	DefaultMaxItemSize  = 1024 * 1024
+)
+
+// Storage engines for Config.Storage.
+const (
+	// StorageHeap keeps each item in its own heap objects.
+	StorageHeap = "heap"
+	// StorageArena keeps items in slab-allocated pages, trading some memory
+	// lost to chunk rounding for far less garbage collector work.
+	StorageArena = "arena"
+)
+
+// Config configures a Server.
+//
+// ListenAddr and ListenAddrs are TCP addresses or "unix:/path/to.sock".
+// TLSClientAuth is one of none, request, require, verify-if-given and
+// require-and-verify; empty means require-and-verify when TLSCAFile is set.
+// Credentials enables authentication when non-nil, and ACL restricts what each
+// authenticated user may do when non-nil. Version is reported by the
+// version command. Zero limits take the Default* values, except MaxConns where
+// 0 means unlimited. IdleTimeout bounds the wait for the next command, and
+// ReadTimeout and WriteTimeout bound reading and answering one command; 0
+// disables each. The shorter of IdleTimeout and ReadTimeout also bounds the
+// TLS handshake, which otherwise has a 10 second limit. Storage is StorageHeap (the default) or StorageArena.
+// Backend, when set, replaces the built-in cache; MaxBytes, TargetBytes,
+// MaxEvictPerOp, IncrSlidingTTLSeconds and Storage are then unused.
+// Compression rules select values to store flate-compressed at
+// CompressionLevel (flate's levels; 0 means flate.BestSpeed).
+type Config struct {
+	ListenAddr            string
+	ListenAddrs           []string
+	UnixSocketPerm        os.FileMode
+	MaxBytes              int64
+	TargetBytes           int64
+	MaxEvictPerOp         int
+	IncrSlidingTTLSeconds int64
+	Storage               string
+	Backend               Backend
+	Compression           []CompressionRule
+	CompressionLevel      int
+	MaxLineLength         int
+	MaxKeyLength          int
+	MaxItemSize           int
+	MaxConns              int
+	MetricsListenAddr     string
+	AdminListenAddr       string
+	AdminToken            string
+	IdleTimeout           time.Duration
+	ReadTimeout           time.Duration
+	WriteTimeout          time.Duration
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSCAFile             string
+	TLSClientAuth         string
+	Credentials           Credentials
+	ACL                   *ACL
+	Version               string
+	Verbose               bool
+	Logger                *slog.Logger
+}
+
+type Server struct {
+	cfg   Config
+	cache Backend
+	// closing is cancelled by Close, to stop a memory shrink in progress.
+	closing       context.Context
+	cancelClosing context.CancelFunc
+	// view is cache when it implements ViewBackend, for gets.
+	view ViewBackend
+
+	mu            sync.RWMutex
+	listeners     []net.Listener
+	httpEndpoints []httpEndpoint
+	readyCh       chan struct{}
+	readyOnce     sync.Once
+	closed        bool
+	httpClosed    bool
+	creds         Credentials
+	aclRules      *ACL
+
+	connsMu      sync.Mutex
+	conns        map[*timeoutConn]struct{}
+	shuttingDown atomic.Bool
+
+	commands    map[string]*Command
+	middleware  []Middleware
+	handlerOnce sync.Once
+	handler     Handler
+
+	metrics    *metrics
+	compressor *compressor
+	verbose    atomic.Bool
+
+	maxConns      atomic.Int64
+	currConns     atomic.Int64
+	totalConns    atomic.Int64
+	rejectedConns atomic.Int64
+	idleTimeouts  atomic.Int64
+	readTimeouts  atomic.Int64
+	writeTimeouts atomic.Int64
+
+	logger *slog.Logger
+}
+
+func NewServer(cfg Config) *Server {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if cfg.MaxLineLength <= 0 {
+		cfg.MaxLineLength = DefaultMaxLineLength
+	}
+	if cfg.MaxKeyLength <= 0 {
+		cfg.MaxKeyLength = DefaultMaxKeyLength
+	}
+	if cfg.MaxItemSize <= 0 {
+		cfg.MaxItemSize = DefaultMaxItemSize
+	}
+
+	c := cfg.Backend
+	if c == nil {
+		opts := []cache.Option{
+			cache.WithMaxBytes(cfg.MaxBytes),
+			cache.WithTargetBytes(cfg.TargetBytes),
+			cache.WithMaxEvictPerOp(cfg.MaxEvictPerOp),
+			cache.WithIncrSlidingTTL(time.Duration(cfg.IncrSlidingTTLSeconds) * time.Second),
+		}
+		if cfg.Storage == StorageArena {
+			opts = append(opts, cache.WithArena())
+		}
+		c = cache.New(opts...)
+	}
+
+	s := &Server{
+		cfg:        cfg,
+		cache:      c,
+		readyCh:    make(chan struct{}),
+		creds:      cfg.Credentials,
+		aclRules:   cfg.ACL,
+		conns:      make(map[*timeoutConn]struct{}),
+		metrics:    newMetrics(),
+		compressor: newCompressor(cfg.Compression, cfg.CompressionLevel),
+		logger:     logger,
+	}
+	s.closing, s.cancelClosing = context.WithCancel(context.Background())
+	s.view, _ = c.(ViewBackend)
+	s.commands = s.builtinCommands()
+	s.SetMaxConns(cfg.MaxConns)
+	s.verbose.Store(cfg.Verbose)
+	return s
+}
+
+func (s *Server) Ready() <-chan struct{} {
+	return s.readyCh
+}
+
+// Addr returns the address of the first listener.
+func (s *Server) Addr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.listeners) == 0 {
+		return ""
+	}
+	return s.listeners[0].Addr().String()
+}
+
+func (s *Server) Addrs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	addrs := make([]string, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr().String())
+	}
+	return addrs
+}
+
+// ListenAndServe listens on the configured addresses and serves until ctx is
+// done or Close is called.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	lns, err := s.listenAll()
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx, lns)
+}
+
+// Serve serves connections accepted from ln, which can be any listener,
+// including an in-memory one, until ctx is done or Close is called. The
+// listen address and TLS settings of the Config are not used; ln is closed
+// when Serve returns.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if err := s.validate(); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	return s.serve(ctx, []net.Listener{ln})
+}
+
+func (s *Server) validate() error {
+	switch s.cfg.Storage {
+	case "", StorageHeap, StorageArena:
+	default:
+		return fmt.Errorf("invalid storage: %q", s.cfg.Storage)
+	}
+	if l := s.cfg.CompressionLevel; l < flate.HuffmanOnly || l > flate.BestCompression {
+		return fmt.Errorf("invalid compression level: %d", l)
+	}
+	return s.validateACL(s.cfg.ACL)
+}
+
+func (s *Server) serve(ctx context.Context, lns []net.Listener) error {
+	eps, err := s.listenHTTP()
+	if err != nil {
+		for _, ln := range lns {
+			_ = ln.Close()
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		for _, ln := range lns {
+			_ = ln.Close()
+		}
+		for _, ep := range eps {
+			_ = ep.ln.Close()
+		}
+		return nil
+	}
+	s.listeners = lns
+	s.httpEndpoints = eps
+	s.mu.Unlock()
+	for _, ep := range eps {
+		go s.serveHTTP(ep)
+	}
+	s.readyOnce.Do(func() { close(s.readyCh) })
+
+	for _, ln := range lns {
+		s.logf("listening on %s", ln.Addr().String())
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+
+	errCh := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() {
+			errCh <- s.acceptLoop(ln)
+		}()
+	}
+
+	var firstErr error
+	for range lns {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+			_ = s.Close()
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) acceptLoop(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				s.logf("temporary accept error: %v", err)
+				continue
+			}
+			s.logf("accept error: %v", err)
+			return err
+		}
+
+		if !s.admitConn(conn) {
+			continue
+		}
+		go func() {
+			defer s.releaseConn()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Cache returns the cache the server serves, for direct use in the same
+// process, or nil when Config.Backend is not a *cache.Cache.
+func (s *Server) Cache() *cache.Cache {
+	c, _ := s.cache.(*cache.Cache)
+	return c
+}
+
+// Backend returns the backend the server serves.
+func (s *Server) Backend() Backend {
+	return s.cache
+}
+
+// Resize changes the cache memory limits; see cache.Cache.Resize. A shrink
+// stops early with context.Canceled when the server is closed, keeping the
+// new limits. It fails unless the backend is a TunableBackend.
+func (s *Server) Resize(maxBytes, targetBytes int64) error {
+	tb, ok := s.cache.(TunableBackend)
+	if !ok {
+		return errResizeUnsupported
+	}
+	if err := tb.Resize(s.closing, maxBytes, targetBytes); err != nil {
+		return err
+	}
+	cs := s.cache.Stats()
+	s.logger.Info("cache resized", "max_bytes", cs.MaxBytes, "target_bytes", cs.TargetBytes, "used_bytes", cs.UsedBytes)
+	return nil
+}
+
+// Close stops accepting connections and closes the HTTP endpoints. Open
+// connections are left running; use Shutdown to drain them.
+func (s *Server) Close() error {
+	err := s.closeListeners()
+	if httpErr := s.closeHTTP(); err == nil {
+		err = httpErr
+	}
+	return err
+}
+
+// closeListeners closes the protocol listeners and stops a memory shrink in
+// progress.
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cancelClosing()
+	var firstErr error
+	for _, ln := range s.listeners {
+		if err := ln.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) closeHTTP() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.httpClosed {
+		return nil
+	}
+	s.httpClosed = true
+	var firstErr error
+	for _, ep := range s.httpEndpoints {
+		if err := ep.srv.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if !s.verbose.Load() {
+		return
+	}
+	s.logger.Info(fmt.Sprintf(format, args...))
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"testing"
@@ -11,11 +13,21 @@ import (
 func newPipeSession(t *testing.T) (net.Conn, func()) {
 	t.Helper()
 
-	srv := NewServer(Config{
+	return newPipeSessionConfig(t, Config{
 		MaxBytes:      1 << 20,
 		TargetBytes:   (1 << 20) * 95 / 100,
 		MaxEvictPerOp: 64,
 	})
+}
+
+func newPipeSessionConfig(t *testing.T, cfg Config) (net.Conn, func()) {
+	t.Helper()
+
+	return newPipeSessionServer(t, NewServer(cfg))
+}
+
+func newPipeSessionServer(t *testing.T, srv *Server) (net.Conn, func()) {
+	t.Helper()
 
 	serverSide, clientSide := net.Pipe()
 	go srv.handleConn(serverSide)
@@ -159,3 +171,95 @@ func TestMultiGetAndBadDataChunk(t *testing.T) {
 		t.Fatalf("unexpected bad chunk response: %q", resp)
 	}
 }
+
+func TestCacheMemlimit(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 30})
+	serverSide, conn := net.Pipe()
+	go srv.handleConn(serverSide)
+	defer conn.Close()
+
+	resp := sendCommand(t, conn, "cache_memlimit 64 32\r\n", "\r\n")
+	if resp != "OK\r\n" {
+		t.Fatalf("unexpected cache_memlimit response: %q", resp)
+	}
+	if st := srv.Cache().Stats(); st.MaxBytes != 64<<20 || st.TargetBytes != 32<<20 {
+		t.Fatalf("unexpected limits: %+v", st)
+	}
+
+	resp = sendCommand(t, conn, "cache_memlimit 0\r\n", "\r\n")
+	if resp != "CLIENT_ERROR invalid megabytes\r\n" {
+		t.Fatalf("unexpected invalid cache_memlimit response: %q", resp)
+	}
+}
+
+func TestArenaStorage(t *testing.T) {
+	conn, stop := newPipeSessionConfig(t, Config{MaxBytes: 1 << 20, Storage: StorageArena})
+	defer stop()
+
+	resp := sendCommand(t, conn, "set k 3 0 5\r\nhello\r\n", "\r\n")
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	resp = sendCommand(t, conn, "get k\r\n", "END\r\n")
+	if resp != "VALUE k 3 5\r\nhello\r\nEND\r\n" {
+		t.Fatalf("unexpected get response: %q", resp)
+	}
+
+	err := NewServer(Config{ListenAddr: "127.0.0.1:0", Storage: "disk"}).ListenAndServe(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid storage") {
+		t.Fatalf("expected invalid storage error, got: %v", err)
+	}
+}
+
+func TestGetBuffersReleased(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 8 << 20, Storage: StorageArena})
+	var keys []string
+	for i := range 20 {
+		key := fmt.Sprintf("k%d", i)
+		keys = append(keys, key)
+		if err := srv.Cache().Set(key, 0, make([]byte, 60<<10)); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	w := &ResponseWriter{Writer: bufio.NewWriter(io.Discard)}
+	if err := srv.handleGetLike(w, keys, false); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if cap(w.valueBuf) <= maxRetainedBuf {
+		t.Fatalf("valueBuf capacity %d, want over %d", cap(w.valueBuf), maxRetainedBuf)
+	}
+	w.release()
+	if w.valueBuf != nil || w.hits[0].Item.Value != nil {
+		t.Fatal("large get buffers were retained")
+	}
+
+	if err := srv.handleGetLike(w, keys[:1], false); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	w.release()
+	if cap(w.valueBuf) == 0 {
+		t.Fatal("small get buffer was dropped")
+	}
+}
+
+func TestChunkedValues(t *testing.T) {
+	for _, storage := range []string{StorageHeap, StorageArena} {
+		srv := NewServer(Config{MaxBytes: 4 << 20, Storage: storage})
+		conn, stop := newPipeSessionServer(t, srv)
+
+		value := strings.Repeat("0123456789abcdef", 10000)
+		resp := sendCommand(t, conn, fmt.Sprintf("set big 9 0 %d\r\n%s\r\n", len(value), value), "\r\n")
+		if resp != "STORED\r\n" {
+			t.Fatalf("%s: unexpected set response: %q", storage, resp)
+		}
+		if item, _ := srv.Cache().Peek("big"); len(item.Chunks) != 3 {
+			t.Fatalf("%s: value stored in %d chunks, want 3", storage, len(item.Chunks))
+		}
+		resp = sendCommand(t, conn, "get big\r\n", "END\r\n")
+		if want := fmt.Sprintf("VALUE big 9 %d\r\n%s\r\nEND\r\n", len(value), value); resp != want {
+			t.Fatalf("%s: unexpected get response of %d bytes", storage, len(resp))
+		}
+		stop()
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks for drained connections.
+var shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown stops accepting connections, closes idle ones and lets the others
+// finish their current command before closing them. When ctx is done first,
+// the remaining connections are closed and ctx's error is returned. The HTTP
+// endpoints close last, so the admin /readyz reports 503 during the drain.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	closeErr := s.closeListeners()
+	err := s.drain(ctx)
+	if httpErr := s.closeHTTP(); closeErr == nil {
+		closeErr = httpErr
+	}
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// drain waits for the tracked connections to go away, closing each once it
+// is idle, and closes the rest when ctx is done.
+func (s *Server) drain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() == 0 && s.currConns.Load() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// trackConn registers c for Shutdown. It returns false once shutdown has
+// started.
+func (s *Server) trackConn(c *timeoutConn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.shuttingDown.Load() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(c *timeoutConn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, c)
+}
+
+// closeIdleConns closes connections waiting for a command and returns how
+// many connections remain tracked.
+func (s *Server) closeIdleConns() int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for c := range s.conns {
+		c.mu.Lock()
+		if c.idle {
+			_ = c.Conn.Close()
+		}
+		c.mu.Unlock()
+	}
+	return len(s.conns)
+}
+
+func (s *Server) closeAllConns() {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for c := range s.conns {
+		_ = c.Conn.Close()
+	}
+}
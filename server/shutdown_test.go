@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	srv, stop := startServer(t, Config{ListenAddr: "127.0.0.1:0"})
+	defer stop()
+
+	conn, resp := dialAndRead(t, srv.Addr(), "version\r\n")
+	defer conn.Close()
+	if resp != "VERSION (devel)\r\n" {
+		t.Fatalf("unexpected version response: %q", resp)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected idle connection to be closed, got: %v", err)
+	}
+}
+
+func TestShutdownWaitsForInFlightCommand(t *testing.T) {
+	srv, stop := startServer(t, Config{ListenAddr: "127.0.0.1:0", MaxBytes: 1 << 20})
+	defer stop()
+
+	conn := dialServer(t, srv.Addr())
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("set k 0 0 5\r\nab")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	waitFor(t, func() bool { return srv.Stats().CurrConnections == 1 })
+	// Let the server start reading the command.
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown returned before the in-flight set finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := conn.Write([]byte("cde\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || line != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q %v", line, err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected connection to close after the command, got: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	if item, ok := srv.Cache().Get("k"); !ok || string(item.Value) != "abcde" {
+		t.Fatal("in-flight set was not stored")
+	}
+}
+
+func TestShutdownDeadlineForcesClose(t *testing.T) {
+	srv, stop := startServer(t, Config{ListenAddr: "127.0.0.1:0"})
+	defer stop()
+
+	conn := dialServer(t, srv.Addr())
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("set k 0 0 5\r\nab")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	waitFor(t, func() bool { return srv.Stats().CurrConnections == 1 })
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected connection to be closed")
+	}
+}
+
+func TestCommandArrivingAtShutdownIsNotRun(t *testing.T) {
+	srv := NewServer(Config{MaxBytes: 1 << 20})
+	conn, stop := newPipeSessionServer(t, srv)
+	defer stop()
+	if resp := sendCommand(t, conn, "version\r\n", "\r\n"); resp != "VERSION (devel)\r\n" {
+		t.Fatalf("unexpected version response: %q", resp)
+	}
+
+	waitFor(t, func() bool {
+		srv.connsMu.Lock()
+		defer srv.connsMu.Unlock()
+		for c := range srv.conns {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.idle
+		}
+		return false
+	})
+	// Shutdown starts while the connection waits for its next command, as if
+	// it ran between the first byte arriving and the command starting.
+	srv.shuttingDown.Store(true)
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	// The server may close the pipe before it has taken the whole command.
+	_, _ = conn.Write([]byte("set k 0 0 1\r\nv\r\n"))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected connection to close, got: %v", err)
+	}
+	if _, ok := srv.Cache().Get("k"); ok {
+		t.Fatal("command arriving at shutdown was run")
+	}
+}
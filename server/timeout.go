@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// timeoutConn applies per command cycle deadlines and counts the first
+// deadline that expires on the connection. It is also the handle Shutdown
+// uses to find idle connections.
+type timeoutConn struct {
+	net.Conn
+	s *Server
+
+	// mu guards writes to idle, which only the serving goroutine makes.
+	mu sync.Mutex
+	// idle is true while waiting for the first byte of a command.
+	idle     bool
+	timedOut bool
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && !c.timedOut {
+		c.timedOut = true
+		if c.idle {
+			c.s.idleTimeouts.Add(1)
+			c.s.logf("idle timeout from %s", remoteAddrString(c.Conn))
+		} else {
+			c.s.readTimeouts.Add(1)
+			c.s.logf("read timeout from %s", remoteAddrString(c.Conn))
+		}
+	}
+	return n, err
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && !c.timedOut {
+		// The peer is not draining its socket buffer.
+		c.timedOut = true
+		c.s.writeTimeouts.Add(1)
+		c.s.logf("write timeout from %s: slow client", remoteAddrString(c.Conn))
+	}
+	return n, err
+}
+
+// beginIdle arms the idle deadline before waiting for the next command. It
+// returns false when the server is shutting down and the connection should be
+// closed instead.
+func (c *timeoutConn) beginIdle() bool {
+	c.mu.Lock()
+	c.idle = true
+	c.mu.Unlock()
+	if c.s.shuttingDown.Load() {
+		return false
+	}
+	if c.s.cfg.IdleTimeout > 0 || c.s.cfg.ReadTimeout > 0 {
+		_ = c.Conn.SetReadDeadline(deadline(c.s.cfg.IdleTimeout))
+	}
+	return true
+}
+
+// beginCommand marks the connection busy and arms the read and write
+// deadlines once a command has started arriving; they cover the rest of the
+// line, any payload and the reply. It returns false when shutdown started
+// while the connection still counted as idle, as Shutdown may have closed it
+// then, and the command should not run.
+func (c *timeoutConn) beginCommand() bool {
+	c.mu.Lock()
+	c.idle = false
+	c.mu.Unlock()
+	if c.s.shuttingDown.Load() {
+		return false
+	}
+	if c.s.cfg.IdleTimeout > 0 || c.s.cfg.ReadTimeout > 0 {
+		_ = c.Conn.SetReadDeadline(deadline(c.s.cfg.ReadTimeout))
+	}
+	if c.s.cfg.WriteTimeout > 0 {
+		_ = c.Conn.SetWriteDeadline(deadline(c.s.cfg.WriteTimeout))
+	}
+	return true
+}
+
+// defaultHandshakeTimeout bounds the TLS handshake when neither IdleTimeout
+// nor ReadTimeout is set.
+var defaultHandshakeTimeout = 10 * time.Second
+
+// handshakeTimeout returns the shorter of IdleTimeout and ReadTimeout, or
+// defaultHandshakeTimeout when both are unlimited.
+func (s *Server) handshakeTimeout() time.Duration {
+	d := s.cfg.IdleTimeout
+	if r := s.cfg.ReadTimeout; r > 0 && (d <= 0 || r < d) {
+		d = r
+	}
+	if d <= 0 {
+		return defaultHandshakeTimeout
+	}
+	return d
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// payloadError keeps deadline errors so the connection is dropped, and
+// reports any other failure to read a payload as a bad data chunk.
+func payloadError(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	return errBadDataChunk
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialServer(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv, stop := startServer(t, Config{ListenAddr: "127.0.0.1:0", IdleTimeout: 50 * time.Millisecond})
+	defer stop()
+
+	conn := dialServer(t, srv.Addr())
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected server to close idle connection, got: %v", err)
+	}
+	waitFor(t, func() bool { return srv.Stats().IdleTimeouts == 1 })
+}
+
+func TestReadTimeoutMidPayload(t *testing.T) {
+	srv, stop := startServer(t, Config{ListenAddr: "127.0.0.1:0", ReadTimeout: 50 * time.Millisecond})
+	defer stop()
+
+	conn := dialServer(t, srv.Addr())
+	if _, err := conn.Write([]byte("set k 0 0 10\r\nab")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected server to close stalled connection, got: %v", err)
+	}
+	waitFor(t, func() bool { return srv.Stats().ReadTimeouts == 1 })
+	if st := srv.Stats(); st.IdleTimeouts != 0 || st.WriteTimeouts != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestWriteTimeoutDropsSlowClient(t *testing.T) {
+	const size = 4 << 20
+	srv, stop := startServer(t, Config{
+		ListenAddr:   "127.0.0.1:0",
+		MaxBytes:     64 << 20,
+		MaxItemSize:  size,
+		WriteTimeout: 100 * time.Millisecond,
+	})
+	defer stop()
+
+	conn := dialServer(t, srv.Addr())
+	payload := fmt.Sprintf("set big 0 0 %d\r\n%s\r\n", size, strings.Repeat("x", size))
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// Ask for far more than the socket buffers hold without reading replies.
+	if _, err := conn.Write([]byte(strings.Repeat("get big\r\n", 64))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	waitFor(t, func() bool { return srv.Stats().WriteTimeouts == 1 })
+}
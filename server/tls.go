@@ -0,0 +1,194 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsReloadInterval bounds how often certificate files are stat'ed.
+var tlsReloadInterval = time.Second
+
+func parseClientAuth(s string, hasCA bool) (tls.ClientAuthType, error) {
+	switch s {
+	case "":
+		if hasCA {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("invalid tls client auth: %q", s)
+	}
+}
+
+// certReloader serves the certificate and client CA pool from disk and
+// reloads them when a file's modification time changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	auth     tls.ClientAuthType
+	logf     func(format string, args ...any)
+
+	mu        sync.Mutex
+	cfg       *tls.Config
+	modTimes  [3]time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile string, auth tls.ClientAuthType, logf func(string, ...any)) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		auth:     auth,
+		logf:     logf,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+func (r *certReloader) current() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checkedAt) >= tlsReloadInterval {
+		r.checkedAt = now
+		if r.changedLocked() {
+			if err := r.loadLocked(); err != nil {
+				r.logf("tls reload failed, keeping previous certificate: %v", err)
+			} else {
+				r.logf("tls certificate reloaded")
+			}
+		}
+	}
+	return r.cfg
+}
+
+func (r *certReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+func (r *certReloader) loadLocked() error {
+	modTimes, err := r.statLocked()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.auth,
+	}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	r.cfg = cfg
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *certReloader) changedLocked() bool {
+	modTimes, err := r.statLocked()
+	if err != nil {
+		return false
+	}
+	return modTimes != r.modTimes
+}
+
+func (r *certReloader) statLocked() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (s *Server) tlsEnabled() bool {
+	return s.cfg.TLSCertFile != "" || s.cfg.TLSKeyFile != ""
+}
+
+func (s *Server) newTLSConfig() (*tls.Config, error) {
+	if s.cfg.TLSCertFile == "" || s.cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("both tls cert and key are required")
+	}
+	auth, err := parseClientAuth(s.cfg.TLSClientAuth, s.cfg.TLSCAFile != "")
+	if err != nil {
+		return nil, err
+	}
+	if auth >= tls.VerifyClientCertIfGiven && s.cfg.TLSCAFile == "" {
+		return nil, fmt.Errorf("tls client auth %q requires a CA file", s.cfg.TLSClientAuth)
+	}
+	r, err := newCertReloader(s.cfg.TLSCertFile, s.cfg.TLSKeyFile, s.cfg.TLSCAFile, auth, s.logf)
+	if err != nil {
+		return nil, err
+	}
+	return r.tlsConfig(), nil
+}
+
+// peerIdentity names the verified (or, without a CA, presented) client
+// certificate: its CommonName, else its first DNS, URI or email SAN.
+func peerIdentity(state tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := state.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "utsuro test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns PEM encoded certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func tlsRoundTrip(cfg *tls.Config, addr, cmd string) (string, *tls.ConnectionState, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	state := conn.ConnectionState()
+	return line, &state, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	prev := tlsReloadInterval
+	tlsReloadInterval = 0
+	defer func() { tlsReloadInterval = prev }()
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server-key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	mod := time.Now().Add(-time.Minute)
+	serverCert, serverKey := ca.issue(t, 10, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, mod)
+	writeFile(t, keyFile, serverKey, mod)
+	writeFile(t, caFile, ca.pem, mod)
+
+	var logs syncBuffer
+	srv, stop := startServer(t, Config{
+		ListenAddr:  "127.0.0.1:0",
+		MaxBytes:    1 << 20,
+		TLSCertFile: certFile,
+		TLSKeyFile:  keyFile,
+		TLSCAFile:   caFile,
+		Verbose:     true,
+		Logger:      slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	defer stop()
+
+	clientCertPEM, clientKeyPEM := ca.issue(t, 20, "svc-a", x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCfg := &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}}
+
+	resp, state, err := tlsRoundTrip(clientCfg, srv.Addr(), "set k 0 0 1\r\nv\r\n")
+	if err != nil {
+		t.Fatalf("mTLS round trip failed: %v", err)
+	}
+	if resp != "STORED\r\n" {
+		t.Fatalf("unexpected set response: %q", resp)
+	}
+	if got := state.PeerCertificates[0].SerialNumber.Int64(); got != 10 {
+		t.Fatalf("server cert serial = %d, want 10", got)
+	}
+	if !strings.Contains(logs.String(), `identity=\"svc-a\"`) {
+		t.Fatalf("client identity not logged: %s", logs.String())
+	}
+
+	if _, _, err := tlsRoundTrip(&tls.Config{RootCAs: roots}, srv.Addr(), "get k\r\n"); err == nil {
+		t.Fatal("connection without client certificate should fail")
+	}
+
+	serverCert, serverKey = ca.issue(t, 11, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, serverCert, mod.Add(30*time.Second))
+	writeFile(t, keyFile, serverKey, mod.Add(30*time.Second))
+
+	_, state, err = tlsRoundTrip(clientCfg, srv.Addr(), "get k\r\n")
+	if err != nil {
+		t.Fatalf("round trip after reload failed: %v", err)
+	}
+	if got := state.PeerCertificates[0].SerialNumber.Int64(); got != 11 {
+		t.Fatalf("server cert serial after reload = %d, want 11", got)
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	if got, _ := parseClientAuth("", true); got != tls.RequireAndVerifyClientCert {
+		t.Fatalf("default with CA = %v", got)
+	}
+	if got, _ := parseClientAuth("", false); got != tls.NoClientCert {
+		t.Fatalf("default without CA = %v", got)
+	}
+	if _, err := parseClientAuth("bogus", false); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestTLSHandshakeBounded(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server-key.pem")
+	certPEM, keyPEM := ca.issue(t, 10, "server", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+	cfg := Config{ListenAddr: "127.0.0.1:0", TLSCertFile: certFile, TLSKeyFile: keyFile}
+
+	if got := NewServer(cfg).handshakeTimeout(); got != defaultHandshakeTimeout {
+		t.Fatalf("handshake timeout without timeouts = %v", got)
+	}
+	cfg.IdleTimeout, cfg.ReadTimeout = time.Minute, 50*time.Millisecond
+	if got := NewServer(cfg).handshakeTimeout(); got != 50*time.Millisecond {
+		t.Fatalf("handshake timeout = %v, want the read timeout", got)
+	}
+
+	srv, stop := startServer(t, cfg)
+	defer stop()
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected stalled handshake to be closed, got: %v", err)
+	}
+
+	// Shutdown closes a connection still waiting for its ClientHello.
+	cfg.IdleTimeout, cfg.ReadTimeout = time.Minute, 0
+	srv, stop = startServer(t, cfg)
+	defer stop()
+	conn, err = net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool { return srv.Stats().CurrConnections == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package utsurotest starts utsuro servers for tests.
+package utsurotest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/catatsuy/utsuro/server"
+)
+
+// shutdownTimeout bounds how long cleanup waits for open connections.
+const shutdownTimeout = 3 * time.Second
+
+// NewServer starts a server with the default configuration on an ephemeral
+// loopback port and shuts it down when the test ends. Its address is
+// srv.Addr().
+func NewServer(t testing.TB) *server.Server {
+	t.Helper()
+	return NewServerConfig(t, server.Config{})
+}
+
+// NewServerConfig is NewServer with cfg. The listen addresses in cfg are
+// ignored.
+func NewServerConfig(t testing.TB, cfg server.Config) *server.Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("utsurotest: listen: %v", err)
+	}
+	srv := server.NewServer(cfg)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(context.Background(), ln)
+	}()
+
+	select {
+	case <-srv.Ready():
+	case err := <-errCh:
+		t.Fatalf("utsurotest: server failed before ready: %v", err)
+	case <-time.After(shutdownTimeout):
+		t.Fatal("utsurotest: server did not become ready")
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+		if err := <-errCh; err != nil {
+			t.Errorf("utsurotest: serve returned error: %v", err)
+		}
+	})
+	return srv
+}
@@ -0,0 +1,28 @@
+package utsurotest
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestNewServer(t *testing.T) {
+	srv := NewServer(t)
+
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("set k 0 0 1\r\nv\r\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "STORED\r\n" {
+		t.Fatalf("unexpected reply: %q %v", line, err)
+	}
+	if _, ok := srv.Cache().Get("k"); !ok {
+		t.Fatal("value missing from the cache")
+	}
+}